@@ -0,0 +1,179 @@
+// Command alerts queries the alert history recorded by core.ErrorHandlerImpl.
+//
+// Usage:
+//
+//	alerts [-file history.jsonl] list [-since 24h] [-fingerprint F] [-level N] [-suppressed[=false]] [-failed] [-limit N]
+//	alerts [-file history.jsonl] stats [-since 24h] [-level N] [-limit N]
+//	alerts [-file history.jsonl] show <fingerprint>
+//
+// -suppressed lists only suppressed alerts, -suppressed=false only sent ones, both are listed without it.
+// Without -file the history file is resolved from the app config in the current directory.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/itskovichanton/core/pkg/core"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	fs := flag.NewFlagSet("alerts", flag.ExitOnError)
+	fileName := fs.String("file", "", "alert history file")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("command expected: list, stats or show")
+	}
+
+	history, err := openHistory(*fileName)
+	if err != nil {
+		return err
+	}
+
+	cmd, cmdArgs := fs.Arg(0), fs.Args()[1:]
+	switch cmd {
+	case "list":
+		filter, err := parseFilter(cmd, cmdArgs)
+		if err != nil {
+			return err
+		}
+		records, err := history.List(filter)
+		if err != nil {
+			return err
+		}
+		printRecords(records)
+	case "stats":
+		filter, err := parseFilter(cmd, cmdArgs)
+		if err != nil {
+			return err
+		}
+		stats, err := history.Stats(filter)
+		if err != nil {
+			return err
+		}
+		printStats(stats)
+	case "show":
+		if len(cmdArgs) == 0 {
+			return fmt.Errorf("fingerprint expected")
+		}
+		filter := &core.AlertFilter{Fingerprint: cmdArgs[0]}
+		stats, err := history.Stats(filter)
+		if err != nil {
+			return err
+		}
+		if len(stats) == 0 {
+			return fmt.Errorf("no alerts with fingerprint %v", cmdArgs[0])
+		}
+		records, err := history.List(filter)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			Stats   *core.AlertStats    `json:"stats"`
+			Records []*core.AlertRecord `json:"records"`
+		}{stats[0], records})
+	default:
+		return fmt.Errorf("unknown command %v", cmd)
+	}
+	return nil
+}
+
+func openHistory(fileName string) (core.IAlertHistoryService, error) {
+	// the app may be appending to the file, compacting it from here would lose its records
+	r := &core.AlertHistoryServiceImpl{FileName: fileName, ReadOnly: true}
+	if len(fileName) == 0 {
+		// LoadConfig parses the process flags, so hide ours from it
+		args := os.Args
+		os.Args = os.Args[:1]
+		config, err := (&core.ConfigServiceImpl{}).LoadConfig()
+		os.Args = args
+		if err != nil {
+			return nil, err
+		}
+		r.Config = config
+	}
+	return r, r.Init()
+}
+
+func parseFilter(cmd string, args []string) (*core.AlertFilter, error) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	since := fs.Duration("since", 0, "only alerts fired within this period")
+	fingerprint := fs.String("fingerprint", "", "only alerts with this fingerprint")
+	level := fs.Int("level", 0, "minimal alert level")
+	suppressed := &optionalBool{}
+	fs.Var(suppressed, "suppressed", "only suppressed alerts, =false only sent ones")
+	failed := fs.Bool("failed", false, "only alerts with failed delivery")
+	limit := fs.Int("limit", 50, "max number of rows")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	r := &core.AlertFilter{
+		Fingerprint: *fingerprint,
+		MinLevel:    *level,
+		FailedOnly:  *failed,
+		Suppressed:  suppressed.v,
+		Limit:       *limit,
+	}
+	if *since > 0 {
+		r.Since = time.Now().Add(-*since)
+	}
+	return r, nil
+}
+
+// optionalBool is a boolean flag that stays nil unless it is given
+type optionalBool struct {
+	v *bool
+}
+
+func (b *optionalBool) String() string {
+	if b == nil || b.v == nil {
+		return ""
+	}
+	return strconv.FormatBool(*b.v)
+}
+
+func (b *optionalBool) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	b.v = &v
+	return nil
+}
+
+func (b *optionalBool) IsBoolFlag() bool {
+	return true
+}
+
+func printRecords(records []*core.AlertRecord) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tLEVEL\tFINGERPRINT\tSUPPRESSED\tDELIVERY\tSUBJECT")
+	for _, r := range records {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", r.CreatedAt.Format(time.RFC3339), r.Level, r.Fingerprint, r.Suppressed, r.Delivery, r.Subject)
+	}
+	w.Flush()
+}
+
+func printStats(stats []*core.AlertStats) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FINGERPRINT\tCOUNT\tSUPPRESSED\tFAILED\tMAX LEVEL\tFIRST\tLAST\tSUBJECT")
+	for _, s := range stats {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", s.Fingerprint, s.Count, s.Suppressed, s.Failed, s.MaxLevel,
+			s.FirstAt.Format(time.RFC3339), s.LastAt.Format(time.RFC3339), s.Subject)
+	}
+	w.Flush()
+}
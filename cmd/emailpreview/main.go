@@ -0,0 +1,201 @@
+// Command emailpreview renders email templates and captures outgoing mail for preview.
+//
+// Usage:
+//
+//	emailpreview render [-dir resources/email] [-i18n resources/i18n] [-locale ru] [-data sample.json] [-part html|text|subject] <template>
+//	emailpreview serve [-http 127.0.0.1:8025] [-smtp 127.0.0.1:2525] [-dir resources/email] [-i18n resources/i18n] [-samples resources/email/samples]
+//
+// serve starts an SMTP sink, point email.address of the app to it, and a web UI listing captured
+// messages. /render?name=T&locale=L&data=sample.json renders a template with sample data,
+// data is a JSON file of the samples dir.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/itskovichanton/core/pkg/core"
+	"github.com/itskovichanton/core/pkg/core/email"
+	"github.com/itskovichanton/core/pkg/core/i18n"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("command expected: render or serve")
+	}
+	switch args[0] {
+	case "render":
+		return render(args[1:])
+	case "serve":
+		return serve(args[1:])
+	}
+	return fmt.Errorf("unknown command %v", args[0])
+}
+
+func render(args []string) error {
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	dir := fs.String("dir", "resources/email", "templates dir")
+	messagesDir := fs.String("i18n", "resources/i18n", "message catalog dir")
+	locale := fs.String("locale", "", "template locale")
+	dataFileName := fs.String("data", "", "JSON file with sample data")
+	part := fs.String("part", "html", "part to print: html, text or subject")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("template name expected")
+	}
+
+	registry, err := newRegistry(*dir, *messagesDir)
+	if err != nil {
+		return err
+	}
+	r, err := renderTemplate(registry, fs.Arg(0), *locale, *dataFileName)
+	if err != nil {
+		return err
+	}
+	switch *part {
+	case "text":
+		fmt.Println(r.Text)
+	case "subject":
+		fmt.Println(r.Subject)
+	default:
+		fmt.Println(r.HTML)
+	}
+	return nil
+}
+
+func serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	httpAddr := fs.String("http", "127.0.0.1:8025", "web UI address")
+	smtpAddr := fs.String("smtp", "127.0.0.1:2525", "SMTP sink address")
+	dir := fs.String("dir", "resources/email", "templates dir")
+	messagesDir := fs.String("i18n", "resources/i18n", "message catalog dir")
+	samplesDir := fs.String("samples", "", "dir of the sample data files of /render, <dir>/samples by default")
+	fs.Parse(args)
+	if len(*samplesDir) == 0 {
+		*samplesDir = filepath.Join(*dir, "samples")
+	}
+
+	registry, err := newRegistry(*dir, *messagesDir)
+	if err != nil {
+		return err
+	}
+	capture := email.NewCaptureServer(*smtpAddr)
+	if err = capture.Start(); err != nil {
+		return err
+	}
+	defer capture.Close()
+
+	ui := &previewUI{capture: capture, registry: registry, samplesDir: *samplesDir}
+	http.HandleFunc("/", ui.index)
+	http.HandleFunc("/message", ui.message)
+	http.HandleFunc("/render", ui.render)
+	fmt.Printf("SMTP sink on %v, web UI on %v\n", capture.Addr, *httpAddr)
+	return http.ListenAndServe(*httpAddr, nil)
+}
+
+func newRegistry(dir, messagesDir string) (*core.EmailTemplateRegistryImpl, error) {
+	if err := i18n.Default.LoadDir(messagesDir); err != nil {
+		return nil, err
+	}
+	r := &core.EmailTemplateRegistryImpl{Dir: dir}
+	return r, r.Init()
+}
+
+func renderTemplate(registry core.IEmailTemplateRegistry, name, locale, dataFileName string) (*core.RenderedTemplate, error) {
+	var data interface{}
+	if len(dataFileName) > 0 {
+		bytes, err := os.ReadFile(dataFileName)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(bytes, &data); err != nil {
+			return nil, err
+		}
+	}
+	return registry.Render(name, locale, data)
+}
+
+type previewUI struct {
+	capture    *email.CaptureServer
+	registry   core.IEmailTemplateRegistry
+	samplesDir string
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Captured mail</title></head><body>
+<h3>Captured mail ({{len .}})</h3>
+<table border="1" cellpadding="4">
+<tr><th>#</th><th>Received</th><th>From</th><th>To</th><th>Subject</th><th>Attachments</th></tr>
+{{range $i, $m := .}}<tr>
+<td>{{$i}}</td><td>{{$m.ReceivedAt.Format "15:04:05"}}</td><td>{{$m.From}}</td><td>{{$m.To}}</td>
+<td><a href="/message?id={{$i}}">{{$m.Subject}}</a> (<a href="/message?id={{$i}}&part=text">text</a>, <a href="/message?id={{$i}}&part=raw">raw</a>)</td>
+<td>{{range $m.Attachments}}{{.Name}} {{end}}</td>
+</tr>{{end}}
+</table></body></html>`))
+
+func (u *previewUI) index(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	indexTemplate.Execute(w, u.capture.Messages())
+}
+
+func (u *previewUI) message(w http.ResponseWriter, r *http.Request) {
+	messages := u.capture.Messages()
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil || id < 0 || id >= len(messages) {
+		http.NotFound(w, r)
+		return
+	}
+	m := messages[id]
+	switch r.URL.Query().Get("part") {
+	case "raw":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(m.Data)
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(m.Text))
+	default:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(m.HTML))
+	}
+}
+
+func (u *previewUI) render(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	dataFileName, err := sampleFileName(u.samplesDir, q.Get("data"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rendered, err := renderTemplate(u.registry, q.Get("name"), q.Get("locale"), dataFileName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(rendered.HTML))
+}
+
+// sampleFileName resolves a /render data file inside dir, the web UI must not read other files
+func sampleFileName(dir, name string) (string, error) {
+	if len(name) == 0 {
+		return "", nil
+	}
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) || filepath.Ext(clean) != ".json" {
+		return "", fmt.Errorf("data must be a JSON file of the samples dir")
+	}
+	return filepath.Join(dir, clean), nil
+}
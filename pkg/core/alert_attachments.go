@@ -0,0 +1,183 @@
+package core
+
+import (
+	"compress/gzip"
+	"github.com/itskovichanton/goava/pkg/goava/errs"
+	"github.com/itskovichanton/goava/pkg/goava/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+)
+
+const (
+	defaultEmailAttachmentsMaxSize = 10 * 1024 * 1024
+	defaultFRAttachmentsMaxSize    = 5 * 1024 * 1024
+)
+
+type AlertAttachments struct {
+	MaxEmailSize string
+	MaxFRSize    string
+}
+
+func (c *ErrorHandlerImpl) initAttachmentLimits() error {
+	c.emailAttachmentsMaxSize = defaultEmailAttachmentsMaxSize
+	c.frAttachmentsMaxSize = defaultFRAttachmentsMaxSize
+	var err error
+	if c.Config.Alerts != nil && c.Config.Alerts.Attachments != nil {
+		if s := c.Config.Alerts.Attachments.MaxEmailSize; len(s) > 0 {
+			if c.emailAttachmentsMaxSize, err = utils.ParseMemory(s); err != nil {
+				return err
+			}
+		}
+		if s := c.Config.Alerts.Attachments.MaxFRSize; len(s) > 0 {
+			if c.frAttachmentsMaxSize, err = utils.ParseMemory(s); err != nil {
+				return err
+			}
+		}
+	}
+	// EmailServiceImpl rejects the whole message above its own limit, so the alert must stay within it
+	emailMaxSize := uint64(defaultEmailMaxAttachmentsSize)
+	if s := c.Config.GetStr("email", "maxattachmentssize"); len(s) > 0 {
+		if emailMaxSize, err = utils.ParseMemory(s); err != nil {
+			return err
+		}
+	}
+	if c.emailAttachmentsMaxSize == 0 || c.emailAttachmentsMaxSize > emailMaxSize {
+		c.emailAttachmentsMaxSize = emailMaxSize
+	}
+	return nil
+}
+
+// AttachLogExcerpt attaches the last maxBytes of logFileName to the alert.
+func (c *ErrorHandlerImpl) AttachLogExcerpt(a *AlertParams, logFileName string, maxBytes int64) error {
+	src, err := os.Open(logFileName)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() > maxBytes {
+		if _, err = src.Seek(info.Size()-maxBytes, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	dst, err := c.Config.GetTempFile(filepath.Base(logFileName) + ".*.txt")
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(dst, src); err != nil {
+		utils.CloseAndRemove(dst)
+		return err
+	}
+	a.Attachments = append(a.Attachments, dst)
+	a.tempFiles = append(a.tempFiles, dst)
+	return nil
+}
+
+func (c *ErrorHandlerImpl) AttachHeapProfile(a *AlertParams) error {
+	f, err := c.Config.GetTempFile("heap.*.pprof")
+	if err != nil {
+		return err
+	}
+	runtime.GC()
+	if err = pprof.WriteHeapProfile(f); err != nil {
+		utils.CloseAndRemove(f)
+		return err
+	}
+	a.Attachments = append(a.Attachments, f)
+	a.tempFiles = append(a.tempFiles, f)
+	return nil
+}
+
+// fitAttachments fits files into maxSize in total: a file that doesn't fit into the rest of the budget
+// is gzipped or truncated, the ones that find no room at all are skipped.
+// The copies it makes are returned as temps, the caller removes them once the alert is sent.
+func (c *ErrorHandlerImpl) fitAttachments(files []*os.File, maxSize uint64) (r, temps []*os.File) {
+	remaining := maxSize
+	for _, f := range files {
+		if maxSize > 0 && remaining == 0 {
+			c.logError("attachment "+f.Name()+" skipped", errs.NewBaseError("attachments exceed "+utils.ByteCountIEC(maxSize)))
+			continue
+		}
+		fitted, err := c.fitAttachment(f, remaining)
+		if err != nil {
+			c.logError("attachment "+f.Name()+" skipped", err)
+			continue
+		}
+		if fitted != f {
+			temps = append(temps, fitted)
+		}
+		r = append(r, fitted)
+		if maxSize > 0 {
+			remaining -= utils.GetFileSize(fitted.Name())
+		}
+	}
+	return r, temps
+}
+
+// fitAttachment returns f itself if it fits maxSize, otherwise its gzipped copy,
+// otherwise the copy of its head truncated to maxSize.
+func (c *ErrorHandlerImpl) fitAttachment(f *os.File, maxSize uint64) (*os.File, error) {
+	if maxSize == 0 || utils.GetFileSize(f.Name()) <= maxSize {
+		return f, nil
+	}
+
+	gz, err := c.copyAttachment(f, ".*.gz", func(dst io.Writer, src io.Reader) error {
+		w := gzip.NewWriter(dst)
+		if _, err := io.Copy(w, src); err != nil {
+			return err
+		}
+		return w.Close()
+	})
+	if err != nil {
+		return nil, err
+	}
+	if utils.GetFileSize(gz.Name()) <= maxSize {
+		return gz, nil
+	}
+	utils.CloseAndRemove(gz)
+
+	return c.copyAttachment(f, ".*.truncated", func(dst io.Writer, src io.Reader) error {
+		_, err := io.CopyN(dst, src, int64(maxSize))
+		return err
+	})
+}
+
+func (c *ErrorHandlerImpl) copyAttachment(f *os.File, suffix string, copier func(dst io.Writer, src io.Reader) error) (*os.File, error) {
+	src, err := os.Open(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	dst, err := c.Config.GetTempFile(filepath.Base(f.Name()) + suffix)
+	if err != nil {
+		return nil, err
+	}
+	if err = copier(dst, src); err != nil {
+		utils.CloseAndRemove(dst)
+		return nil, err
+	}
+	return dst, nil
+}
+
+func removeFiles(files []*os.File) {
+	for _, f := range files {
+		utils.CloseAndRemove(f)
+	}
+}
+
+func fileNames(files []*os.File) []string {
+	r := make([]string, 0, len(files))
+	for _, f := range files {
+		r = append(r, f.Name())
+	}
+	return r
+}
@@ -0,0 +1,363 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/itskovichanton/goava/pkg/goava/errs"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	AlertChannelEmail = "email"
+	AlertChannelFR    = "fr"
+
+	AlertDeliverySent    = "sent"
+	AlertDeliveryPosted  = "posted"
+	AlertDeliverySkipped = "skipped"
+
+	defaultAlertHistoryMaxAge     = 30 * 24 * time.Hour
+	defaultAlertHistoryMaxRecords = 10000
+	alertHistoryCompactEvery      = 100
+)
+
+var errAlertHistoryReadOnly = errs.NewBaseError("alert history is opened read-only")
+
+type AlertHistory struct {
+	Disabled   bool
+	MaxAge     time.Duration
+	MaxRecords int
+}
+
+type AlertRecord struct {
+	Fingerprint string            `json:"fingerprint"`
+	Subject     string            `json:"subject"`
+	Message     string            `json:"message,omitempty"`
+	Level       int               `json:"level"`
+	Meta        *RequestMeta      `json:"meta,omitempty"`
+	Channels    []string          `json:"channels,omitempty"`
+	Suppressed  bool              `json:"suppressed,omitempty"`
+	Delivery    map[string]string `json:"delivery,omitempty"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	DeliveredAt *time.Time        `json:"deliveredAt,omitempty"`
+}
+
+func (r *AlertRecord) Failed() bool {
+	for _, outcome := range r.Delivery {
+		if outcome != AlertDeliverySent && outcome != AlertDeliveryPosted && outcome != AlertDeliverySkipped {
+			return true
+		}
+	}
+	return false
+}
+
+type AlertFilter struct {
+	Since, Until time.Time
+	Fingerprint  string
+	MinLevel     int
+	Suppressed   *bool
+	FailedOnly   bool
+	Limit        int
+}
+
+func (f *AlertFilter) matches(r *AlertRecord) bool {
+	if f == nil {
+		return true
+	}
+	if !f.Since.IsZero() && r.CreatedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && r.CreatedAt.After(f.Until) {
+		return false
+	}
+	if len(f.Fingerprint) > 0 && f.Fingerprint != r.Fingerprint {
+		return false
+	}
+	if r.Level < f.MinLevel {
+		return false
+	}
+	if f.Suppressed != nil && *f.Suppressed != r.Suppressed {
+		return false
+	}
+	if f.FailedOnly && !r.Failed() {
+		return false
+	}
+	return true
+}
+
+type AlertStats struct {
+	Fingerprint string    `json:"fingerprint"`
+	Subject     string    `json:"subject"`
+	Count       int       `json:"count"`
+	Suppressed  int       `json:"suppressed"`
+	Failed      int       `json:"failed"`
+	MaxLevel    int       `json:"maxLevel"`
+	FirstAt     time.Time `json:"firstAt"`
+	LastAt      time.Time `json:"lastAt"`
+}
+
+type IAlertHistoryService interface {
+	Record(r *AlertRecord) error
+	List(f *AlertFilter) ([]*AlertRecord, error)
+	Stats(f *AlertFilter) ([]*AlertStats, error)
+	Compact() error
+}
+
+// AlertHistoryServiceImpl keeps alert records as JSON lines in a single file under the work dir.
+// The records are indexed in memory, queries only read the lines appended since the previous one
+// and reload the file after it was compacted by another process.
+type AlertHistoryServiceImpl struct {
+	IAlertHistoryService
+
+	Config     *Config
+	FileName   string
+	MaxAge     time.Duration
+	MaxRecords int
+	// ReadOnly opens the history of a running app for queries: Init doesn't compact it and Record and Compact fail
+	ReadOnly bool
+
+	lock     sync.Mutex
+	appended int
+	records  []*AlertRecord
+	// file and offset tell which part of the file records cover
+	file   os.FileInfo
+	offset int64
+}
+
+func (c *AlertHistoryServiceImpl) Init() error {
+	if len(c.FileName) == 0 {
+		c.FileName = filepath.Join(c.Config.GetDir("alerts"), "history.jsonl")
+	}
+	c.MaxAge = defaultAlertHistoryMaxAge
+	c.MaxRecords = defaultAlertHistoryMaxRecords
+	if c.Config != nil && c.Config.Alerts != nil && c.Config.Alerts.History != nil {
+		if c.Config.Alerts.History.MaxAge > 0 {
+			c.MaxAge = c.Config.Alerts.History.MaxAge
+		}
+		if c.Config.Alerts.History.MaxRecords > 0 {
+			c.MaxRecords = c.Config.Alerts.History.MaxRecords
+		}
+	}
+	if c.ReadOnly {
+		return nil
+	}
+	return c.Compact()
+}
+
+func (c *AlertHistoryServiceImpl) Record(r *AlertRecord) error {
+	if c.ReadOnly {
+		return errAlertHistoryReadOnly
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(c.FileName, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	c.appended++
+	if c.appended >= alertHistoryCompactEvery {
+		c.appended = 0
+		return c.compact()
+	}
+	return nil
+}
+
+// List returns matching records, newest first. The records are shared with the index, callers must not modify them.
+func (c *AlertHistoryServiceImpl) List(f *AlertFilter) ([]*AlertRecord, error) {
+	c.lock.Lock()
+	records, err := c.load()
+	c.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var r []*AlertRecord
+	for i := len(records) - 1; i >= 0; i-- {
+		if !f.matches(records[i]) {
+			continue
+		}
+		r = append(r, records[i])
+		if f != nil && f.Limit > 0 && len(r) >= f.Limit {
+			break
+		}
+	}
+	return r, nil
+}
+
+// Stats groups matching records by fingerprint, most frequent first.
+func (c *AlertHistoryServiceImpl) Stats(f *AlertFilter) ([]*AlertStats, error) {
+	var all *AlertFilter
+	if f != nil {
+		unlimited := *f
+		unlimited.Limit = 0
+		all = &unlimited
+	}
+	records, err := c.List(all)
+	if err != nil {
+		return nil, err
+	}
+
+	byFingerprint := map[string]*AlertStats{}
+	var r []*AlertStats
+	for _, rec := range records {
+		s := byFingerprint[rec.Fingerprint]
+		if s == nil {
+			s = &AlertStats{
+				Fingerprint: rec.Fingerprint,
+				Subject:     rec.Subject,
+				FirstAt:     rec.CreatedAt,
+				LastAt:      rec.CreatedAt,
+			}
+			byFingerprint[rec.Fingerprint] = s
+			r = append(r, s)
+		}
+		s.Count++
+		if rec.Suppressed {
+			s.Suppressed++
+		}
+		if rec.Failed() {
+			s.Failed++
+		}
+		if rec.Level > s.MaxLevel {
+			s.MaxLevel = rec.Level
+		}
+		if rec.CreatedAt.Before(s.FirstAt) {
+			s.FirstAt = rec.CreatedAt
+		}
+		if rec.CreatedAt.After(s.LastAt) {
+			s.LastAt = rec.CreatedAt
+		}
+	}
+
+	sort.SliceStable(r, func(i, j int) bool {
+		return r[i].Count > r[j].Count
+	})
+	if f != nil && f.Limit > 0 && len(r) > f.Limit {
+		r = r[:f.Limit]
+	}
+	return r, nil
+}
+
+func (c *AlertHistoryServiceImpl) Compact() error {
+	if c.ReadOnly {
+		return errAlertHistoryReadOnly
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.compact()
+}
+
+func (c *AlertHistoryServiceImpl) compact() error {
+	records, err := c.load()
+	if err != nil || len(records) == 0 {
+		return err
+	}
+
+	// the slice returned by List may still be in use, so the kept records go to a new one
+	var kept []*AlertRecord
+	minTime := time.Now().Add(-c.MaxAge)
+	for _, r := range records {
+		if c.MaxAge <= 0 || r.CreatedAt.After(minTime) {
+			kept = append(kept, r)
+		}
+	}
+	if c.MaxRecords > 0 && len(kept) > c.MaxRecords {
+		kept = kept[len(kept)-c.MaxRecords:]
+	}
+
+	tmpFileName := c.FileName + ".tmp"
+	f, err := os.Create(tmpFileName)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, r := range kept {
+		if err = enc.Encode(r); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFileName)
+		return err
+	}
+	if err = os.Rename(tmpFileName, c.FileName); err != nil {
+		return err
+	}
+	c.records, c.file, c.offset = kept, nil, 0
+	if info, err := os.Stat(c.FileName); err == nil {
+		c.file, c.offset = info, info.Size()
+	}
+	return nil
+}
+
+// load brings the index up to date with the file and returns it. Only complete lines are consumed:
+// the writer may be in the middle of appending one.
+func (c *AlertHistoryServiceImpl) load() ([]*AlertRecord, error) {
+	info, err := os.Stat(c.FileName)
+	if os.IsNotExist(err) {
+		c.records, c.file, c.offset = nil, nil, 0
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.file == nil || !os.SameFile(c.file, info) || info.Size() < c.offset {
+		c.records, c.offset = nil, 0
+	}
+	c.file = info
+	if info.Size() == c.offset {
+		return c.records, nil
+	}
+
+	f, err := os.Open(c.FileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err = f.Seek(c.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReaderSize(f, 64*1024)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.offset += int64(len(line))
+		var rec AlertRecord
+		if line = bytes.TrimSpace(line); len(line) == 0 || json.Unmarshal(line, &rec) != nil {
+			continue
+		}
+		c.records = append(c.records, &rec)
+	}
+	return c.records, nil
+}
@@ -0,0 +1,83 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAlertHistoryIndex(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "history.jsonl")
+	writer := &AlertHistoryServiceImpl{FileName: fileName}
+	if err := writer.Init(); err != nil {
+		t.Fatal(err)
+	}
+	reader := &AlertHistoryServiceImpl{FileName: fileName, ReadOnly: true}
+	if err := reader.Init(); err != nil {
+		t.Fatal(err)
+	}
+	expect := func(f *AlertFilter, fingerprints ...string) {
+		t.Helper()
+		records, err := reader.List(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r []string
+		for _, rec := range records {
+			r = append(r, rec.Fingerprint)
+		}
+		if len(r) != len(fingerprints) {
+			t.Fatalf("%v expected, got %v", fingerprints, r)
+		}
+		for i := range r {
+			if r[i] != fingerprints[i] {
+				t.Fatalf("%v expected, got %v", fingerprints, r)
+			}
+		}
+	}
+	record := func(fingerprint string, suppressed bool) {
+		t.Helper()
+		if err := writer.Record(&AlertRecord{Fingerprint: fingerprint, Suppressed: suppressed, CreatedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expect(nil)
+	record("a", false)
+	record("b", true)
+	expect(nil, "b", "a")
+
+	// the reader picks up the lines appended by the writer and skips the one it is in the middle of
+	record("c", false)
+	line, _ := json.Marshal(&AlertRecord{Fingerprint: "d", CreatedAt: time.Now()})
+	f, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(line[:len(line)/2])
+	expect(nil, "c", "b", "a")
+	f.Write(append(line[len(line)/2:], '\n'))
+	f.Close()
+	suppressed, sent := true, false
+	expect(&AlertFilter{Suppressed: &suppressed}, "b")
+	expect(&AlertFilter{Suppressed: &sent, Limit: 2}, "d", "c")
+
+	// compaction by the writer replaces the file under the reader
+	writer.MaxRecords = 2
+	if err = writer.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	expect(nil, "d", "c")
+	record("e", false)
+	expect(nil, "e", "d", "c")
+
+	stats, err := reader.Stats(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 3 {
+		t.Fatalf("3 fingerprints expected, got %v", len(stats))
+	}
+}
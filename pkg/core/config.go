@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type AppInfo struct {
@@ -32,18 +33,64 @@ type Apis struct {
 }
 
 type FR struct {
+	Name        string
 	Url         string
 	DeveloperId int
+	// DeveloperIds are the recipients of posts delivered to this endpoint, DeveloperId is added to them
+	DeveloperIds []int
+	// Token is sent as a bearer token
+	Token string
+	// MinLevel is the lowest Post level delivered to this endpoint
+	MinLevel int
+	// Secondary takes over when this endpoint stays unavailable after all retries
+	Secondary *FR
+	// Timeout bounds delivery of one post including retries
+	Timeout     time.Duration
+	MaxAttempts int
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+}
+
+type Alerts struct {
+	EmailDisabled bool
+	From          string
+	Emails        []string
+	Template      string
+	Subject       string
+	Attachments   *AlertAttachments
+	History       *AlertHistory
 }
 
 type Config struct {
-	Profile  string
-	FR, FR2  *FR
+	Profile string
+	FR, FR2 *FR
+	// FRs are the feedback-reporter endpoints every post is fanned out to, FR and FR2 are used when empty
+	FRs      []*FR
+	Alerts   *Alerts
 	App      *AppInfo
 	Props    *Props
 	Settings map[string]interface{}
 }
 
+// GetFRTargets returns FRs, or FR and FR2 (posts of level 3 and above) for configs of the older format
+func (c *Config) GetFRTargets() []*FR {
+	if len(c.FRs) > 0 {
+		return c.FRs
+	}
+	var r []*FR
+	if c.FR != nil {
+		r = append(r, c.FR)
+	}
+	if c.FR2 != nil {
+		fr2 := *c.FR2
+		if fr2.MinLevel == 0 {
+			fr2.MinLevel = 3
+		}
+		r = append(r, &fr2)
+	}
+	return r
+}
+
 func (c *Config) GetLogsDir() string {
 	return c.GetDir("logs")
 }
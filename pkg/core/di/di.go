@@ -1,12 +1,18 @@
 package di
 
 import (
+	"fmt"
 	"github.com/itskovichanton/core/pkg/core"
 	"github.com/itskovichanton/core/pkg/core/app"
 	"github.com/itskovichanton/core/pkg/core/cmdservice"
+	"github.com/itskovichanton/core/pkg/core/frmclient"
+	"github.com/itskovichanton/core/pkg/core/i18n"
 	"github.com/itskovichanton/core/pkg/core/logger"
 	"github.com/itskovichanton/goava/pkg/goava"
+	"github.com/itskovichanton/goava/pkg/goava/errs"
+	"github.com/mitchellh/mapstructure"
 	"github.com/patrickmn/go-cache"
+	"github.com/spf13/cast"
 	"go.uber.org/dig"
 	"net/http"
 )
@@ -27,9 +33,14 @@ func (c *DI) buildContainer(container *dig.Container) *dig.Container {
 	container.Provide(c.NewConfigService)
 	container.Provide(c.NewConfig)
 	container.Provide(c.NewFRService)
+	container.Provide(c.NewFrmClient)
+	container.Provide(c.NewMessageCatalog)
+	container.Provide(c.NewEmailTemplateRegistry)
 	container.Provide(c.NewEmailService)
 	container.Provide(c.NewErrorHandler)
 	container.Provide(c.NewAlertParamsPostProcessor)
+	container.Provide(c.NewPanicHandler)
+	container.Provide(c.NewAlertHistoryService)
 	container.Provide(c.NewGenerator)
 	container.Provide(c.NewCmdRunnerService)
 	container.Provide(c.NewCmdService)
@@ -85,17 +96,122 @@ func (c *DI) NewHttpClient() *http.Client {
 	}
 }
 
-func (c *DI) NewEmailService(config *core.Config) core.IEmailService {
-	return &core.EmailServiceImpl{
-		Config: config,
+// NewMessageCatalog loads the messages of Props.ResourcesPath/i18n into i18n.Default, which validation uses directly
+func (c *DI) NewMessageCatalog(config *core.Config) (*i18n.Catalog, error) {
+	if locale := config.GetStr("i18n", "defaultlocale"); len(locale) > 0 {
+		i18n.Default.DefaultLocale = locale
+	}
+	if config.Props == nil {
+		return i18n.Default, nil
+	}
+	return i18n.Default, i18n.Default.LoadDir(config.GetResourceFilePath("i18n"))
+}
+
+func (c *DI) NewEmailTemplateRegistry(config *core.Config, messages *i18n.Catalog, loggerService logger.ILoggerService) (core.IEmailTemplateRegistry, error) {
+	dir := config.GetStr("email", "templates", "dir")
+	if len(dir) == 0 {
+		dir = config.GetResourceFilePath("email")
+	}
+	r := &core.EmailTemplateRegistryImpl{
+		Dir:           dir,
+		DefaultLocale: config.GetStr("email", "templates", "defaultlocale"),
+		Messages:      messages,
+		Logger:        loggerService.GetFileLogger("email", "", 30),
+	}
+	return r, r.Init()
+}
+
+func (c *DI) NewEmailService(config *core.Config, templates core.IEmailTemplateRegistry, loggerService logger.ILoggerService) (core.IEmailService, error) {
+	r := &core.EmailServiceImpl{
+		Config:    config,
+		Templates: templates,
+		Logger:    loggerService.GetFileLogger("email", "", 30),
 	}
+	return r, r.Init()
 }
 
-func (c *DI) NewFRService(httpClient *http.Client, config *core.Config) core.IFRService {
+func (c *DI) NewFRService(httpClient *http.Client, config *core.Config, loggerService logger.ILoggerService) core.IFRService {
 	return &core.FRServiceImpl{
 		HttpClient: httpClient,
 		Config:     config,
+		Logger:     loggerService.GetFileLogger("fr", "", 30),
+	}
+}
+
+func (c *DI) NewFrmClient(httpClient *http.Client, config *core.Config, errorHandler core.IErrorHandler) (*frmclient.Client, error) {
+	baseUrl := config.GetStr("frmclient", "baseurl")
+	if len(baseUrl) == 0 && config.Props != nil {
+		baseUrl = config.Props.MainServiceUrl
+	}
+	r := &frmclient.Client{
+		HttpClient: httpClient,
+		Config: &frmclient.Config{
+			BaseUrl:    baseUrl,
+			Headers:    cast.ToStringMapString(config.Get("frmclient", "headers")),
+			Policies:   map[string]*frmclient.Policy{},
+			Token:      config.GetStr("frmclient", "token"),
+			HMACKeyId:  config.GetStr("frmclient", "hmackeyid"),
+			HMACSecret: config.GetStr("frmclient", "hmacsecret"),
+		},
+		OnBreakerStateChange: func(change *frmclient.BreakerStateChange) {
+			msg := fmt.Sprintf("circuit breaker of %v: %v -> %v", change.Endpoint, change.From, change.To)
+			err := errs.NewBaseErrorWithReason(msg, frmclient.ReasonCircuitOpen)
+			if change.Err != nil {
+				err = errs.NewBaseErrorFromCauseMsgReason(change.Err, msg, frmclient.ReasonCircuitOpen)
+			}
+			errorHandler.HandleWithCustomParams(err, func(p *core.AlertParams) {
+				if change.To == frmclient.BreakerClosed {
+					p.Level = frmclient.LogLevelInfo
+				}
+			})
+		},
+	}
+
+	var err error
+	if r.Config.Policy, err = decodePolicy(config.Get("frmclient", "policy")); err != nil {
+		return nil, err
+	}
+	// policies are a list of {prefix, policy fields} entries: viper lowercases map keys and splits them on ".",
+	// so urls can't be keys
+	var entries []*policyEntry
+	if v := config.Get("frmclient", "policies"); v != nil {
+		if err = decodeConfig(v, &entries); err != nil {
+			return nil, err
+		}
 	}
+	for _, e := range entries {
+		if len(e.Prefix) == 0 {
+			return nil, errs.NewBaseError("frmclient.policies: prefix expected")
+		}
+		policy := e.Policy
+		r.Config.Policies[e.Prefix] = &policy
+	}
+	return r, nil
+}
+
+type policyEntry struct {
+	Prefix string
+	Policy frmclient.Policy `mapstructure:",squash"`
+}
+
+func decodePolicy(v interface{}) (*frmclient.Policy, error) {
+	if v == nil {
+		return nil, nil
+	}
+	r := &frmclient.Policy{}
+	return r, decodeConfig(v, r)
+}
+
+func decodeConfig(v interface{}, result interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:           result,
+		WeaklyTypedInput: true,
+		DecodeHook:       mapstructure.StringToTimeDurationHookFunc(),
+	})
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(v)
 }
 
 func (c *DI) NewCache() *cache.Cache {
@@ -115,13 +231,34 @@ func (c *DI) NewAlertParamsPostProcessor() core.IParamsPostProcessor {
 	return r
 }
 
-func (c *DI) NewErrorHandler(paramsPostProcessor core.IParamsPostProcessor, emailService core.IEmailService, config *core.Config, frservice core.IFRService) core.IErrorHandler {
+func (c *DI) NewAlertHistoryService(config *core.Config) (core.IAlertHistoryService, error) {
+	if config.Alerts != nil && config.Alerts.History != nil && config.Alerts.History.Disabled {
+		return nil, nil
+	}
+	r := &core.AlertHistoryServiceImpl{
+		Config: config,
+	}
+	return r, r.Init()
+}
+
+func (c *DI) NewErrorHandler(paramsPostProcessor core.IParamsPostProcessor, emailService core.IEmailService, templates core.IEmailTemplateRegistry, config *core.Config, frservice core.IFRService, alertHistory core.IAlertHistoryService, loggerService logger.ILoggerService) (core.IErrorHandler, error) {
 	r := &core.ErrorHandlerImpl{
+		Templates:           templates,
+		Logger:              loggerService.GetFileLogger("alerts", "", 30),
+		AlertHistory:        alertHistory,
 		ParamsPostProcessor: paramsPostProcessor,
 		EmailService:        emailService,
 		Config:              config,
 		FRService:           frservice,
 	}
+	return r, r.Init()
+}
+
+func (c *DI) NewPanicHandler(errorHandler core.IErrorHandler, config *core.Config) core.IPanicHandler {
+	r := &core.PanicHandlerImpl{
+		ErrorHandler: errorHandler,
+		Config:       config,
+	}
 	r.Init()
 	return r
 }
@@ -0,0 +1,355 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+type CapturedPart struct {
+	Name        string
+	ContentType string
+	Disposition string
+	Data        []byte
+}
+
+type CapturedMessage struct {
+	From        string
+	To          []string
+	Data        []byte
+	Header      mail.Header
+	Subject     string
+	Text        string
+	HTML        string
+	Parts       []*CapturedPart
+	Attachments []*CapturedPart
+	ReceivedAt  time.Time
+	ParseError  string
+}
+
+// CaptureServer is an in-process SMTP sink for tests and dev profiles.
+// It accepts any sender, recipient and credentials and keeps parsed messages in memory.
+type CaptureServer struct {
+	Addr string
+
+	listener net.Listener
+	lock     sync.Mutex
+	messages []*CapturedMessage
+	received chan struct{}
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewCaptureServer creates a sink listening on addr, "127.0.0.1:0" picks a free port
+func NewCaptureServer(addr string) *CaptureServer {
+	if len(addr) == 0 {
+		addr = "127.0.0.1:0"
+	}
+	return &CaptureServer{Addr: addr, received: make(chan struct{}, 1), conns: map[net.Conn]struct{}{}}
+}
+
+func (s *CaptureServer) Start() error {
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	s.listener = l
+	s.closed = false
+	s.lock.Unlock()
+	s.Addr = l.Addr().String()
+	s.wg.Add(1)
+	go s.serve(l)
+	return nil
+}
+
+// Close stops accepting and drops open sessions, pooled clients keep idle ones open until their timeout
+func (s *CaptureServer) Close() error {
+	s.lock.Lock()
+	l := s.listener
+	s.listener = nil
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.lock.Unlock()
+	if l == nil {
+		return nil
+	}
+	err := l.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *CaptureServer) Messages() []*CapturedMessage {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]*CapturedMessage{}, s.messages...)
+}
+
+func (s *CaptureServer) Last() *CapturedMessage {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.messages) == 0 {
+		return nil
+	}
+	return s.messages[len(s.messages)-1]
+}
+
+func (s *CaptureServer) Reset() {
+	s.lock.Lock()
+	s.messages = nil
+	s.lock.Unlock()
+}
+
+// WaitFor blocks until at least n messages are captured or the timeout expires
+func (s *CaptureServer) WaitFor(n int, timeout time.Duration) []*CapturedMessage {
+	deadline := time.After(timeout)
+	for {
+		if r := s.Messages(); len(r) >= n {
+			return r
+		}
+		select {
+		case <-s.received:
+		case <-deadline:
+			return s.Messages()
+		}
+	}
+}
+
+func (s *CaptureServer) serve(l net.Listener) {
+	defer s.wg.Done()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		s.lock.Lock()
+		if s.closed {
+			s.lock.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.lock.Unlock()
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				s.lock.Lock()
+				delete(s.conns, conn)
+				s.lock.Unlock()
+			}()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *CaptureServer) handle(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	reply := func(code int, msg string) {
+		tp.PrintfLine("%d %s", code, msg)
+	}
+
+	reply(220, "capture ESMTP ready")
+	var from string
+	var rcpts []string
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			tp.PrintfLine("250-capture")
+			tp.PrintfLine("250-8BITMIME")
+			tp.PrintfLine("250 AUTH PLAIN LOGIN CRAM-MD5")
+		case "HELO":
+			reply(250, "capture")
+		case "AUTH":
+			if !s.auth(tp, arg) {
+				return
+			}
+		case "MAIL":
+			from, rcpts = trimPath(arg), nil
+			reply(250, "OK")
+		case "RCPT":
+			rcpts = append(rcpts, trimPath(arg))
+			reply(250, "OK")
+		case "DATA":
+			reply(354, "End data with <CR><LF>.<CR><LF>")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.store(from, rcpts, data)
+			from, rcpts = "", nil
+			reply(250, "OK")
+		case "RSET":
+			from, rcpts = "", nil
+			reply(250, "OK")
+		case "NOOP":
+			reply(250, "OK")
+		case "QUIT":
+			reply(221, "Bye")
+			return
+		default:
+			reply(502, "Command not implemented")
+		}
+	}
+}
+
+// auth accepts any credentials for the mechanisms advertised in EHLO
+func (s *CaptureServer) auth(tp *textproto.Conn, arg string) bool {
+	mechanism, initial, _ := strings.Cut(arg, " ")
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		if len(initial) == 0 {
+			tp.PrintfLine("334 ")
+			if _, err := tp.ReadLine(); err != nil {
+				return false
+			}
+		}
+	case "LOGIN":
+		steps := []string{"Username:", "Password:"}
+		if len(initial) > 0 {
+			steps = steps[1:]
+		}
+		for _, step := range steps {
+			tp.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(step)))
+			if _, err := tp.ReadLine(); err != nil {
+				return false
+			}
+		}
+	case "CRAM-MD5":
+		tp.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte("<capture@localhost>")))
+		if _, err := tp.ReadLine(); err != nil {
+			return false
+		}
+	default:
+		tp.PrintfLine("504 Unrecognized authentication type")
+		return true
+	}
+	tp.PrintfLine("235 Authentication successful")
+	return true
+}
+
+func trimPath(arg string) string {
+	if _, path, ok := strings.Cut(arg, ":"); ok {
+		arg = path
+	}
+	arg = strings.TrimSpace(arg)
+	if i := strings.Index(arg, ">"); i >= 0 {
+		arg = arg[:i+1]
+	}
+	return strings.Trim(arg, "<>")
+}
+
+func (s *CaptureServer) store(from string, rcpts []string, data []byte) {
+	m := ParseCapturedMessage(data)
+	m.From = from
+	m.To = rcpts
+	m.ReceivedAt = time.Now()
+
+	s.lock.Lock()
+	s.messages = append(s.messages, m)
+	s.lock.Unlock()
+
+	select {
+	case s.received <- struct{}{}:
+	default:
+	}
+}
+
+// ParseCapturedMessage decodes headers, text and HTML bodies and all MIME parts of a raw message
+func ParseCapturedMessage(data []byte) *CapturedMessage {
+	r := &CapturedMessage{Data: data}
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		r.ParseError = err.Error()
+		return r
+	}
+	r.Header = msg.Header
+	dec := &mime.WordDecoder{}
+	if r.Subject, err = dec.DecodeHeader(msg.Header.Get("Subject")); err != nil {
+		r.Subject = msg.Header.Get("Subject")
+	}
+	if err = r.walk(textproto.MIMEHeader(msg.Header), msg.Body); err != nil {
+		r.ParseError = err.Error()
+	}
+	return r
+}
+
+func (m *CapturedMessage) walk(header textproto.MIMEHeader, body io.Reader) error {
+	contentType := header.Get("Content-Type")
+	if len(contentType) == 0 {
+		contentType = "text/plain"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err = m.walk(p.Header, p); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := io.ReadAll(decodeTransfer(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return err
+	}
+	part := &CapturedPart{ContentType: mediaType, Data: data}
+	if disposition, dparams, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil {
+		part.Disposition = disposition
+		part.Name = dparams["filename"]
+	}
+	if len(part.Name) == 0 {
+		part.Name = params["name"]
+	}
+	m.Parts = append(m.Parts, part)
+
+	switch {
+	case part.Disposition == "attachment":
+		m.Attachments = append(m.Attachments, part)
+	case mediaType == "text/plain" && len(m.Text) == 0:
+		m.Text = string(data)
+	case mediaType == "text/html" && len(m.HTML) == 0:
+		m.HTML = string(data)
+	}
+	return nil
+}
+
+func decodeTransfer(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		// the decoder skips line breaks itself
+		return base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	}
+	return r
+}
@@ -0,0 +1,214 @@
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CanonicalizationSimple  = "simple"
+	CanonicalizationRelaxed = "relaxed"
+)
+
+var DefaultDKIMHeaders = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-ID",
+	"MIME-Version", "Content-Type", "Content-Transfer-Encoding",
+}
+
+var wspRe = regexp.MustCompile(`[ \t]+`)
+
+// DKIMSigner signs built messages per RFC 6376 (rsa-sha256) and RFC 8463 (ed25519-sha256)
+type DKIMSigner struct {
+	Domain   string
+	Selector string
+	Key      crypto.Signer
+	// Headers to sign, DefaultDKIMHeaders when empty. Absent headers are skipped.
+	Headers                []string
+	HeaderCanonicalization string
+	BodyCanonicalization   string
+}
+
+// LoadDKIMKey reads a PEM encoded RSA (PKCS#1 or PKCS#8) or Ed25519 (PKCS#8) private key
+func LoadDKIMKey(fileName string) (crypto.Signer, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("dkim: no PEM block found in " + fileName)
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	}
+	return nil, errors.New("dkim: unsupported key type in " + fileName)
+}
+
+// Sign returns the DKIM-Signature header line (with CRLF) to be prepended to message
+func (s *DKIMSigner) Sign(message []byte) (string, error) {
+	algorithm, hash := "", crypto.Hash(0)
+	switch s.Key.(type) {
+	case *rsa.PrivateKey:
+		algorithm, hash = "rsa-sha256", crypto.SHA256
+	case ed25519.PrivateKey:
+		algorithm = "ed25519-sha256"
+	default:
+		return "", errors.New("dkim: unsupported key type")
+	}
+	headerCanon := s.canonicalization(s.HeaderCanonicalization)
+	bodyCanon := s.canonicalization(s.BodyCanonicalization)
+
+	header, body := splitMessage(message)
+	bodyHash := sha256.Sum256(canonicalizeBody(body, bodyCanon))
+
+	names := s.Headers
+	if len(names) == 0 {
+		names = DefaultDKIMHeaders
+	}
+	fields := parseHeaderFields(header)
+	used := map[int]bool{}
+	var signedNames []string
+	h := sha256.New()
+	for _, name := range names {
+		// Repeated fields are signed bottom-up (RFC 6376 5.4.2)
+		for i := len(fields) - 1; i >= 0; i-- {
+			if used[i] || !strings.EqualFold(fieldName(fields[i]), name) {
+				continue
+			}
+			used[i] = true
+			signedNames = append(signedNames, name)
+			h.Write([]byte(canonicalizeHeader(fields[i], headerCanon)))
+			break
+		}
+	}
+
+	value := "v=1; a=" + algorithm +
+		"; c=" + headerCanon + "/" + bodyCanon +
+		"; d=" + s.Domain +
+		"; s=" + s.Selector +
+		"; t=" + strconv.FormatInt(time.Now().Unix(), 10) +
+		"; h=" + strings.Join(signedNames, ":") +
+		"; bh=" + base64.StdEncoding.EncodeToString(bodyHash[:]) +
+		"; b="
+	sigField := "DKIM-Signature: " + value
+	h.Write([]byte(strings.TrimSuffix(canonicalizeHeader(sigField+"\r\n", headerCanon), "\r\n")))
+
+	signature, err := s.Key.Sign(rand.Reader, h.Sum(nil), hash)
+	if err != nil {
+		return "", err
+	}
+	sigField += base64.StdEncoding.EncodeToString(signature)
+	if headerCanon == CanonicalizationSimple {
+		// Simple canonicalization signs the field as written, so it must not be refolded
+		return sigField + "\r\n", nil
+	}
+	return foldHeader("DKIM-Signature", value+base64.StdEncoding.EncodeToString(signature)), nil
+}
+
+// ParseCanonicalization parses a c= tag value such as "relaxed/simple". A single algorithm
+// applies to the header only, the body is canonicalized simple then (RFC 6376 3.5).
+func ParseCanonicalization(c string) (header, body string, err error) {
+	header, body, found := strings.Cut(strings.ToLower(strings.TrimSpace(c)), "/")
+	if !found {
+		body = CanonicalizationSimple
+	}
+	for _, v := range []string{header, body} {
+		if v != CanonicalizationSimple && v != CanonicalizationRelaxed {
+			return "", "", errors.New("dkim: unknown canonicalization " + c)
+		}
+	}
+	return header, body, nil
+}
+
+func (s *DKIMSigner) canonicalization(c string) string {
+	if strings.EqualFold(c, CanonicalizationSimple) {
+		return CanonicalizationSimple
+	}
+	return CanonicalizationRelaxed
+}
+
+func splitMessage(message []byte) ([]byte, []byte) {
+	if i := bytes.Index(message, []byte("\r\n\r\n")); i >= 0 {
+		return message[:i+2], message[i+4:]
+	}
+	return message, nil
+}
+
+// parseHeaderFields splits the header block into fields, keeping folded continuation lines and CRLFs
+func parseHeaderFields(header []byte) []string {
+	var r []string
+	for _, line := range strings.SplitAfter(string(header), "\r\n") {
+		if len(line) == 0 {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(r) > 0 {
+			r[len(r)-1] += line
+			continue
+		}
+		r = append(r, line)
+	}
+	return r
+}
+
+func fieldName(field string) string {
+	if i := strings.Index(field, ":"); i >= 0 {
+		return strings.TrimSpace(field[:i])
+	}
+	return field
+}
+
+func canonicalizeHeader(field, canonicalization string) string {
+	if canonicalization == CanonicalizationSimple {
+		return field
+	}
+	i := strings.Index(field, ":")
+	if i < 0 {
+		return field
+	}
+	name := strings.ToLower(strings.TrimSpace(field[:i]))
+	value := strings.ReplaceAll(field[i+1:], "\r\n", "")
+	value = strings.TrimSpace(wspRe.ReplaceAllString(value, " "))
+	return name + ":" + value + "\r\n"
+}
+
+func canonicalizeBody(body []byte, canonicalization string) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	if canonicalization == CanonicalizationRelaxed {
+		for i, l := range lines {
+			lines[i] = strings.TrimRight(wspRe.ReplaceAllString(l, " "), " ")
+		}
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		if canonicalization == CanonicalizationRelaxed {
+			return nil
+		}
+		return []byte("\r\n")
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
@@ -0,0 +1,162 @@
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"github.com/emersion/go-msgauth/dkim"
+	"strings"
+	"testing"
+)
+
+func TestDKIMSignatureVerifies(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []crypto.Signer{rsaKey, edKey} {
+		for _, canon := range []string{"relaxed/relaxed", "relaxed/simple", "simple/simple"} {
+			t.Run(keyType(key)+" "+canon, func(t *testing.T) {
+				data := buildSigned(t, key, canon)
+				verifications, err := dkim.VerifyWithOptions(bytes.NewReader(data), &dkim.VerifyOptions{
+					LookupTXT: lookupTXT(t, key),
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(verifications) != 1 {
+					t.Fatalf("expected 1 signature, got %v", len(verifications))
+				}
+				if err = verifications[0].Err; err != nil {
+					t.Fatalf("signature doesn't verify: %v", err)
+				}
+				if !strings.Contains(string(data), "c="+canon+";") {
+					t.Fatalf("c=%v expected in the signature", canon)
+				}
+			})
+		}
+	}
+}
+
+func TestDKIMSignatureDetectsTampering(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachment := base64.StdEncoding.EncodeToString([]byte("line 1\nline 2\n"))
+	for _, tc := range []struct{ name, from, to string }{
+		{"body", attachment, base64.StdEncoding.EncodeToString([]byte("line 1\nline 3\n"))},
+		{"header", "cc@example.org", "cx@example.org"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := buildSigned(t, key, "relaxed/relaxed")
+			if !bytes.Contains(data, []byte(tc.from)) {
+				t.Fatalf("%v not found in the message", tc.from)
+			}
+			tampered := bytes.Replace(data, []byte(tc.from), []byte(tc.to), 1)
+			verifications, err := dkim.VerifyWithOptions(bytes.NewReader(tampered), &dkim.VerifyOptions{
+				LookupTXT: lookupTXT(t, key),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(verifications) != 1 || verifications[0].Err == nil {
+				t.Fatal("tampered message verifies")
+			}
+		})
+	}
+}
+
+func TestParseCanonicalization(t *testing.T) {
+	for _, tc := range []struct{ value, header, body string }{
+		{"relaxed/relaxed", CanonicalizationRelaxed, CanonicalizationRelaxed},
+		{"relaxed/simple", CanonicalizationRelaxed, CanonicalizationSimple},
+		{"Simple/Relaxed", CanonicalizationSimple, CanonicalizationRelaxed},
+		{"relaxed", CanonicalizationRelaxed, CanonicalizationSimple},
+		{"simple", CanonicalizationSimple, CanonicalizationSimple},
+	} {
+		header, body, err := ParseCanonicalization(tc.value)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.value, err)
+		}
+		if header != tc.header || body != tc.body {
+			t.Fatalf("%v: got %v/%v, expected %v/%v", tc.value, header, body, tc.header, tc.body)
+		}
+	}
+	for _, value := range []string{"", "loose", "relaxed/", "/simple", "relaxed/loose"} {
+		if _, _, err := ParseCanonicalization(value); err == nil {
+			t.Fatalf("%q: error expected", value)
+		}
+	}
+}
+
+// buildSigned renders a message with alternative bodies, an attachment and non-ASCII headers through Build
+func buildSigned(t *testing.T, key crypto.Signer, canon string) []byte {
+	header, body, err := ParseCanonicalization(canon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := New("localhost:25")
+	e.DKIM = &DKIMSigner{
+		Domain:                 "example.com",
+		Selector:               "test",
+		Key:                    key,
+		HeaderCanonicalization: header,
+		BodyCanonicalization:   body,
+	}
+	m := &Message{
+		From:     "Отправитель <sender@example.com>",
+		To:       "rcpt@example.org",
+		CC:       "cc@example.org",
+		Subject:  "Тема письма, long enough to be folded by the header writer of the message builder",
+		BodyText: "Привет\r\nHello  \t world  \r\n\r\n",
+		BodyHTML: "<p>Привет</p>",
+		Attachments: []*File{
+			{Name: "report.txt", Type: "text/plain", Data: []byte("line 1\nline 2\n")},
+		},
+	}
+	if err = e.Build(m); err != nil {
+		t.Fatal(err)
+	}
+	data := m.Bytes()
+	if !bytes.HasPrefix(data, []byte("DKIM-Signature: ")) {
+		t.Fatalf("message is not signed:\n%s", data)
+	}
+	return data
+}
+
+func lookupTXT(t *testing.T, key crypto.Signer) func(domain string) ([]string, error) {
+	var record string
+	switch k := key.Public().(type) {
+	case *rsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		record = "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)
+	case ed25519.PublicKey:
+		record = "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(k)
+	}
+	return func(domain string) ([]string, error) {
+		if domain != "test._domainkey.example.com" {
+			t.Fatalf("unexpected lookup of %v", domain)
+		}
+		return []string{record}, nil
+	}
+}
+
+func keyType(key crypto.Signer) string {
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		return "rsa-sha256"
+	}
+	return "ed25519-sha256"
+}
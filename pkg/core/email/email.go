@@ -3,11 +3,13 @@ package email
 import (
 	"bytes"
 	"crypto/tls"
+	"encoding/base64"
+	"errors"
 	"github.com/labstack/gommon/random"
-	"html/template"
-	"net"
 	"net/mail"
 	"net/smtp"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -15,31 +17,52 @@ type (
 	Email struct {
 		Auth        smtp.Auth
 		Header      map[string]string
-		Template    *template.Template
+		TLSMode     TLSMode
+		TLSConfig   *tls.Config
+		DialTimeout time.Duration
+		IOTimeout   time.Duration
+		DKIM        *DKIMSigner
 		smtpAddress string
+		conns       sync.Map
 	}
 
 	Message struct {
-		ID          string  `json:"id"`
-		From        string  `json:"from"`
-		To          string  `json:"to"`
-		CC          string  `json:"cc"`
-		Subject     string  `json:"subject"`
-		BodyText    string  `json:"body_text"`
-		BodyHTML    string  `json:"body_html"`
-		Inlines     []*File `json:"inlines"`
-		Attachments []*File `json:"attachments"`
+		ID   string `json:"id"`
+		From string `json:"from"`
+		To   string `json:"to"`
+		CC   string `json:"cc"`
+		// BCC recipients are passed to RCPT only and never written to headers
+		BCC         string            `json:"bcc"`
+		ReplyTo     string            `json:"reply_to"`
+		Priority    Priority          `json:"priority"`
+		Header      map[string]string `json:"header"`
+		Subject     string            `json:"subject"`
+		BodyText    string            `json:"body_text"`
+		BodyHTML    string            `json:"body_html"`
+		Inlines     []*File           `json:"inlines"`
+		Attachments []*File           `json:"attachments"`
 		buffer      *bytes.Buffer
-		boundary    string
 	}
 
+	// File holds either raw Data or Content already encoded to base64
 	File struct {
 		Name    string
 		Type    string
 		Content string
+		Data    []byte
 	}
 )
 
+type Priority int
+
+const (
+	PriorityNormal Priority = iota
+	PriorityHigh
+	PriorityLow
+)
+
+const maxLineLength = 76
+
 func New(smtpAddress string) *Email {
 	return &Email{
 		smtpAddress: smtpAddress,
@@ -48,126 +71,275 @@ func New(smtpAddress string) *Email {
 }
 
 func (m *Message) writeHeader(key, value string) {
-	m.buffer.WriteString(key)
-	m.buffer.WriteString(": ")
-	m.buffer.WriteString(value)
+	m.buffer.WriteString(foldHeader(key, value))
+}
+
+func (m *Message) writeBoundary(boundary string) {
+	m.buffer.WriteString("--")
+	m.buffer.WriteString(boundary)
 	m.buffer.WriteString("\r\n")
 }
 
-func (m *Message) writeBoundary() {
+func (m *Message) closeBoundary(boundary string) {
 	m.buffer.WriteString("--")
-	m.buffer.WriteString(m.boundary)
+	m.buffer.WriteString(boundary)
+	m.buffer.WriteString("--\r\n")
+}
+
+// writeMultipartHeader starts a multipart entity and returns its boundary
+func (m *Message) writeMultipartHeader(subtype string) string {
+	boundary := random.String(16)
+	m.writeHeader("Content-Type", "multipart/"+subtype+"; boundary="+boundary)
 	m.buffer.WriteString("\r\n")
+	return boundary
 }
 
 func (m *Message) writeText(content string, contentType string) {
-	m.writeBoundary()
 	m.writeHeader("Content-Type", contentType+"; charset=UTF-8")
+	m.writeHeader("Content-Transfer-Encoding", "quoted-printable")
 	m.buffer.WriteString("\r\n")
-	m.buffer.WriteString(content)
+	m.buffer.WriteString(encodeQuotedPrintable(content))
 	m.buffer.WriteString("\r\n")
 	m.buffer.WriteString("\r\n")
 }
 
 func (m *Message) writeFile(f *File, disposition string) {
-	m.writeBoundary()
-	m.writeHeader("Content-Type", f.Type+`; name="`+f.Name+`"`)
-	m.writeHeader("Content-Disposition", disposition+`; filename="`+f.Name+`"`)
+	m.writeHeader("Content-Type", formatMediaType(f.Type, map[string]string{"name": f.Name}))
+	m.writeHeader("Content-Disposition", formatMediaType(disposition, map[string]string{"filename": f.Name}))
+	if disposition == "inline" {
+		m.writeHeader("Content-ID", "<"+f.Name+">")
+	}
 	m.writeHeader("Content-Transfer-Encoding", "base64")
 	m.buffer.WriteString("\r\n")
-	m.buffer.WriteString(f.Content)
-	m.buffer.WriteString("\r\n")
+	m.writeBase64(f)
 	m.buffer.WriteString("\r\n")
 }
 
-func (e *Email) Send(m *Message) (err error) {
+// writeBody lays out the parts as
+// multipart/mixed(multipart/alternative(text/plain, multipart/related(text/html, inlines...)), attachments...).
+// The text part is generated from BodyHTML when BodyText is empty.
+func (m *Message) writeBody() {
+	text := m.BodyText
+	if text == "" && m.BodyHTML != "" {
+		text = HTMLToText(m.BodyHTML)
+	}
+
+	mixed := m.writeMultipartHeader("mixed")
+	m.writeBoundary(mixed)
+	if m.BodyHTML == "" {
+		m.writeText(text, "text/plain")
+		for _, f := range m.Inlines {
+			m.writeBoundary(mixed)
+			m.writeFile(f, "inline")
+		}
+	} else {
+		alternative := m.writeMultipartHeader("alternative")
+		m.writeBoundary(alternative)
+		m.writeText(text, "text/plain")
+		m.writeBoundary(alternative)
+		if len(m.Inlines) > 0 {
+			related := m.writeMultipartHeader("related")
+			m.writeBoundary(related)
+			m.writeText(m.BodyHTML, "text/html")
+			for _, f := range m.Inlines {
+				m.writeBoundary(related)
+				m.writeFile(f, "inline")
+			}
+			m.closeBoundary(related)
+		} else {
+			m.writeText(m.BodyHTML, "text/html")
+		}
+		m.closeBoundary(alternative)
+	}
+
+	for _, f := range m.Attachments {
+		m.writeBoundary(mixed)
+		m.writeFile(f, "attachment")
+	}
+	m.closeBoundary(mixed)
+}
+
+// writeBase64 writes the file content base64-encoded in lines of 76 chars as required by RFC 2045
+func (m *Message) writeBase64(f *File) {
+	encoded := f.Content
+	if f.Data != nil {
+		encoded = base64.StdEncoding.EncodeToString(f.Data)
+	} else {
+		encoded = strings.Join(strings.Fields(encoded), "")
+	}
+	for len(encoded) > maxLineLength {
+		m.buffer.WriteString(encoded[:maxLineLength])
+		m.buffer.WriteString("\r\n")
+		encoded = encoded[maxLineLength:]
+	}
+	if len(encoded) > 0 {
+		m.buffer.WriteString(encoded)
+		m.buffer.WriteString("\r\n")
+	}
+}
+
+// Build renders the message into its internal buffer, ready to be written to SMTP DATA.
+// The result is DKIM-signed when DKIM is set.
+func (e *Email) Build(m *Message) error {
 	// Message header
 	m.buffer = bytes.NewBuffer(make([]byte, 256))
 	m.buffer.Reset()
-	m.boundary = random.String(16)
+	if m.ID == "" {
+		m.ID = newMessageID(m.From)
+	} else if !strings.HasPrefix(m.ID, "<") {
+		m.ID = "<" + m.ID + ">"
+	}
 	m.writeHeader("MIME-Version", "1.0")
 	m.writeHeader("Message-ID", m.ID)
 	m.writeHeader("Date", time.Now().Format(time.RFC1123Z))
-	m.writeHeader("From", m.From)
-	m.writeHeader("To", m.To)
+	m.writeHeader("From", encodeAddressList(m.From))
+	// a CC-only message has no To header at all: RFC 5322 doesn't allow it to be empty
+	if m.To != "" {
+		m.writeHeader("To", encodeAddressList(m.To))
+	}
 	if m.CC != "" {
-		m.writeHeader("CC", m.CC)
+		m.writeHeader("CC", encodeAddressList(m.CC))
+	}
+	if m.ReplyTo != "" {
+		m.writeHeader("Reply-To", encodeAddressList(m.ReplyTo))
 	}
 	if m.Subject != "" {
-		m.writeHeader("Subject", m.Subject)
+		m.writeHeader("Subject", encodeHeaderText(m.Subject))
+	}
+	switch m.Priority {
+	case PriorityHigh:
+		m.writeHeader("X-Priority", "1 (Highest)")
+		m.writeHeader("Importance", "High")
+	case PriorityLow:
+		m.writeHeader("X-Priority", "5 (Lowest)")
+		m.writeHeader("Importance", "Low")
 	}
 	// Extra
 	for k, v := range e.Header {
-		m.writeHeader(k, v)
+		m.writeHeader(k, encodeHeaderText(v))
+	}
+	for k, v := range m.Header {
+		m.writeHeader(k, encodeHeaderText(v))
 	}
-	m.writeHeader("Content-Type", "multipart/mixed; boundary="+m.boundary)
-	m.buffer.WriteString("\r\n")
 
 	// Message body
-	if m.BodyText != "" {
-		m.writeText(m.BodyText, "text/plain")
-	} else if m.BodyHTML != "" {
-		m.writeText(m.BodyHTML, "text/html")
-	} else {
-		m.writeBoundary()
+	m.writeBody()
+
+	if e.DKIM != nil {
+		signature, err := e.DKIM.Sign(m.buffer.Bytes())
+		if err != nil {
+			return err
+		}
+		m.buffer = bytes.NewBuffer(append([]byte(signature), m.buffer.Bytes()...))
 	}
+	return nil
+}
 
-	// Inlines/attachments
-	for _, f := range m.Inlines {
-		m.writeFile(f, "inline")
+// Recipients returns the envelope addresses from To, CC and BCC
+func (m *Message) Recipients() ([]string, error) {
+	var r []string
+	for _, list := range []string{m.To, m.CC, m.BCC} {
+		if strings.TrimSpace(list) == "" {
+			continue
+		}
+		addrs, err := mail.ParseAddressList(list)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			r = append(r, a.Address)
+		}
 	}
-	for _, f := range m.Attachments {
-		m.writeFile(f, "attachment")
+	return r, nil
+}
+
+func (m *Message) Bytes() []byte {
+	if m.buffer == nil {
+		return nil
 	}
-	m.buffer.WriteString("--")
-	m.buffer.WriteString(m.boundary)
-	m.buffer.WriteString("--")
+	return m.buffer.Bytes()
+}
 
-	// Dial
-	c, err := smtp.Dial(e.smtpAddress)
+func (e *Email) Send(m *Message) error {
+	if err := e.Build(m); err != nil {
+		return err
+	}
+	from, rcpts, err := m.Envelope()
 	if err != nil {
-		return
+		return err
 	}
-	defer c.Quit()
+	return e.SendRaw(from, rcpts, m.Bytes())
+}
 
-	// Check if TLS is required
-	if ok, _ := c.Extension("STARTTLS"); ok {
-		host, _, _ := net.SplitHostPort(e.smtpAddress)
-		config := &tls.Config{ServerName: host}
-		if err = c.StartTLS(config); err != nil {
-			return err
-		}
+// SendRaw delivers an already built message in a single SMTP session
+func (e *Email) SendRaw(from string, rcpts []string, data []byte) error {
+	c, err := e.Connect()
+	if err != nil {
+		return err
 	}
-
-	// Authenticate
-	if e.Auth != nil {
-		if err = c.Auth(e.Auth); err != nil {
-			return
-		}
+	if err = e.deliver(c, from, rcpts, data); err != nil {
+		e.close(c)
+		return err
 	}
+	// the message is accepted already, a failed QUIT must not make the caller send it again
+	e.quit(c)
+	return nil
+}
 
-	// Send message
+// Envelope returns the MAIL FROM and RCPT TO addresses of the message
+func (m *Message) Envelope() (string, []string, error) {
 	from, err := mail.ParseAddress(m.From)
 	if err != nil {
-		return
+		return "", nil, err
 	}
-	if err = c.Mail(from.Address); err != nil {
-		return
-	}
-	to, err := mail.ParseAddressList(m.To)
+	rcpts, err := m.Recipients()
 	if err != nil {
-		return
+		return "", nil, err
 	}
-	for _, a := range to {
-		if err = c.Rcpt(a.Address); err != nil {
-			return
+	return from.Address, rcpts, nil
+}
+
+// deliver runs the MAIL, RCPT and DATA transaction over an established session
+func (e *Email) deliver(c *smtp.Client, from string, rcpts []string, data []byte) error {
+	e.touch(c)
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, a := range rcpts {
+		if err := c.Rcpt(a); err != nil {
+			return err
 		}
 	}
 	wc, err := c.Data()
 	if err != nil {
-		return
+		return err
+	}
+	if _, err = wc.Write(data); err != nil {
+		wc.Close()
+		return &DataSentError{Err: err}
 	}
-	defer wc.Close()
-	_, err = m.buffer.WriteTo(wc)
-	return
+	if err = wc.Close(); err != nil {
+		return &DataSentError{Err: err}
+	}
+	return nil
+}
+
+// DataSentError is a failure after the server accepted the DATA command. Unless the server replied
+// with an error code, it may have accepted the message, so sending it again may deliver a duplicate.
+type DataSentError struct {
+	Err error
+}
+
+func (e *DataSentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DataSentError) Unwrap() error {
+	return e.Err
+}
+
+// IsAmbiguousDelivery reports whether err leaves unknown if the message was delivered
+func IsAmbiguousDelivery(err error) bool {
+	var dataErr *DataSentError
+	return errors.As(err, &dataErr) && !isProtocolError(err)
 }
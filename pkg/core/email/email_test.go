@@ -0,0 +1,146 @@
+package email
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startCapture(t *testing.T) *CaptureServer {
+	s := NewCaptureServer("")
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// structure renders the MIME tree of a message as "multipart/mixed(text/plain,application/pdf)"
+func structure(t *testing.T, header textproto.MIMEHeader, body io.Reader) string {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return mediaType
+	}
+	var parts []string
+	mr := multipart.NewReader(body, params["boundary"])
+	for {
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, structure(t, p.Header, p))
+	}
+	return mediaType + "(" + strings.Join(parts, ",") + ")"
+}
+
+func TestMessageStructure(t *testing.T) {
+	s := startCapture(t)
+	e := New(s.Addr)
+	logo := &File{Name: "logo.png", Type: "image/png", Data: []byte("png")}
+	report := &File{Name: "отчёт.pdf", Type: "application/pdf", Data: bytes.Repeat([]byte("pdf"), 100)}
+
+	for _, tc := range []struct {
+		name      string
+		m         *Message
+		structure string
+	}{
+		{"text", &Message{BodyText: "hello"},
+			"multipart/mixed(text/plain)"},
+		{"text with attachment", &Message{BodyText: "hello", Attachments: []*File{report}},
+			"multipart/mixed(text/plain,application/pdf)"},
+		{"html", &Message{BodyHTML: "<p>hello</p>"},
+			"multipart/mixed(multipart/alternative(text/plain,text/html))"},
+		{"html with inlines and attachment", &Message{BodyText: "hello", BodyHTML: `<p>hello</p><img src="cid:logo.png">`, Inlines: []*File{logo}, Attachments: []*File{report}},
+			"multipart/mixed(multipart/alternative(text/plain,multipart/related(text/html,image/png)),application/pdf)"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s.Reset()
+			tc.m.From, tc.m.To, tc.m.Subject = "app@example.com", "bob@example.com", "Отчёт "+tc.name
+			if err := e.Send(tc.m); err != nil {
+				t.Fatal(err)
+			}
+			messages := s.WaitFor(1, time.Second)
+			if len(messages) != 1 {
+				t.Fatal("the message wasn't captured")
+			}
+			captured := messages[0]
+			if len(captured.ParseError) > 0 {
+				t.Fatal(captured.ParseError)
+			}
+			if captured.Subject != tc.m.Subject {
+				t.Fatalf("subject %q expected, got %q", tc.m.Subject, captured.Subject)
+			}
+
+			msg, err := mail.ReadMessage(bytes.NewReader(captured.Data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r := structure(t, textproto.MIMEHeader(msg.Header), msg.Body); r != tc.structure {
+				t.Fatalf("%v expected, got %v", tc.structure, r)
+			}
+
+			if !strings.Contains(captured.Text, "hello") {
+				t.Fatalf("text part %q doesn't contain the body", captured.Text)
+			}
+			if len(tc.m.BodyHTML) > 0 && strings.TrimSpace(captured.HTML) != tc.m.BodyHTML {
+				t.Fatalf("html part %q expected, got %q", tc.m.BodyHTML, captured.HTML)
+			}
+			if len(captured.Attachments) != len(tc.m.Attachments) {
+				t.Fatalf("%v attachments expected, got %v", len(tc.m.Attachments), len(captured.Attachments))
+			}
+			for i, a := range captured.Attachments {
+				if a.Name != tc.m.Attachments[i].Name || !bytes.Equal(a.Data, tc.m.Attachments[i].Data) {
+					t.Fatalf("attachment %v doesn't match: %q", i, a.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestBCCIsNotInHeaders(t *testing.T) {
+	s := startCapture(t)
+	m := &Message{
+		From:     "app@example.com",
+		To:       "bob@example.com",
+		CC:       "Carol <carol@example.com>",
+		BCC:      "audit@example.com, Dave <dave@example.com>",
+		Subject:  "hello",
+		BodyText: "hello",
+	}
+	if err := New(s.Addr).Send(m); err != nil {
+		t.Fatal(err)
+	}
+	messages := s.WaitFor(1, time.Second)
+	if len(messages) != 1 {
+		t.Fatal("the message wasn't captured")
+	}
+	captured := messages[0]
+
+	expected := []string{"bob@example.com", "carol@example.com", "audit@example.com", "dave@example.com"}
+	if strings.Join(captured.To, ",") != strings.Join(expected, ",") {
+		t.Fatalf("envelope recipients %v expected, got %v", expected, captured.To)
+	}
+	if v, ok := captured.Header["Bcc"]; ok {
+		t.Fatalf("Bcc header must not be written, got %v", v)
+	}
+	for _, addr := range []string{"audit@example.com", "dave@example.com"} {
+		if bytes.Contains(captured.Data, []byte(addr)) {
+			t.Fatalf("the message data reveals the BCC recipient %v", addr)
+		}
+	}
+	if captured.Header.Get("Cc") != "\"Carol\" <carol@example.com>" {
+		t.Fatalf("unexpected Cc header %q", captured.Header.Get("Cc"))
+	}
+}
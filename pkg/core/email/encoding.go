@@ -0,0 +1,104 @@
+package email
+
+import (
+	"github.com/labstack/gommon/random"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const maxHeaderLineLength = 78
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeHeaderText encodes unstructured header text into RFC 2047 encoded-words when needed
+func encodeHeaderText(s string) string {
+	if isASCII(s) {
+		return s
+	}
+	return mime.BEncoding.Encode("UTF-8", s)
+}
+
+// encodeAddressList re-renders an address list so that display names are RFC 2047 encoded
+func encodeAddressList(s string) string {
+	addrs, err := mail.ParseAddressList(s)
+	if err != nil {
+		return encodeHeaderText(s)
+	}
+	r := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		r = append(r, a.String())
+	}
+	return strings.Join(r, ", ")
+}
+
+// foldHeader renders "key: value" folding it at whitespace into lines of at most 78 chars (RFC 5322 2.2.3).
+// The first word goes to a continuation line too when it doesn't fit after "key:", only a word longer than a line overflows.
+func foldHeader(key, value string) string {
+	sb := &strings.Builder{}
+	sb.WriteString(key)
+	sb.WriteString(":")
+	lineLen := sb.Len()
+	for _, word := range strings.Fields(value) {
+		if lineLen > 0 && lineLen+1+len(word) > maxHeaderLineLength {
+			sb.WriteString("\r\n")
+			lineLen = 0
+		}
+		sb.WriteString(" ")
+		sb.WriteString(word)
+		lineLen += 1 + len(word)
+	}
+	sb.WriteString("\r\n")
+	return sb.String()
+}
+
+// formatMediaType renders a Content-Type or Content-Disposition value, encoding non-ASCII params per RFC 2231
+func formatMediaType(mediaType string, params map[string]string) string {
+	t, existing, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		t, existing = "application/octet-stream", nil
+	}
+	for k, v := range existing {
+		if _, overridden := params[k]; !overridden {
+			params[k] = v
+		}
+	}
+	return mime.FormatMediaType(t, params)
+}
+
+func encodeQuotedPrintable(s string) string {
+	sb := &strings.Builder{}
+	w := quotedprintable.NewWriter(sb)
+	w.Write([]byte(s))
+	w.Close()
+	return sb.String()
+}
+
+// newMessageID generates an RFC 5322 msg-id using the sender's domain as the right part
+func newMessageID(from string) string {
+	domain := ""
+	if addr, err := mail.ParseAddress(from); err == nil {
+		if at := strings.LastIndex(addr.Address, "@"); at >= 0 {
+			domain = addr.Address[at+1:]
+		}
+	}
+	if len(domain) == 0 {
+		domain, _ = os.Hostname()
+	}
+	if len(domain) == 0 {
+		domain = "localhost"
+	}
+	return "<" + strconv.FormatInt(time.Now().UnixNano(), 36) + "." + random.String(16) + "@" + domain + ">"
+}
@@ -0,0 +1,43 @@
+package email
+
+import (
+	"mime"
+	"strings"
+	"testing"
+)
+
+func TestFoldHeader(t *testing.T) {
+	for _, tc := range []struct{ key, value string }{
+		{"Subject", "short"},
+		{"Subject", encodeHeaderText(strings.Repeat("Отчёт об ошибке ", 10))},
+		{"Subject", encodeHeaderText("Ошибка в модуле оплаты")},
+		{"X-Very-Long-Header-Name", strings.Repeat("word ", 30)},
+		{"To", encodeAddressList("Иван Петров <ivan@example.com>, Пётр Иванов <petr@example.com>")},
+	} {
+		folded := foldHeader(tc.key, tc.value)
+		if !strings.HasSuffix(folded, "\r\n") {
+			t.Fatalf("%q doesn't end with CRLF", folded)
+		}
+		lines := strings.Split(strings.TrimSuffix(folded, "\r\n"), "\r\n")
+		for i, line := range lines {
+			if len(line) > maxHeaderLineLength {
+				t.Fatalf("line %v of %v is %v chars long: %q", i, tc.key, len(line), line)
+			}
+			if i > 0 && !strings.HasPrefix(line, " ") {
+				t.Fatalf("continuation line %q doesn't start with whitespace", line)
+			}
+		}
+
+		unfolded := strings.Join(lines, "")
+		if !strings.HasPrefix(unfolded, tc.key+":") {
+			t.Fatalf("%q lost the header name", unfolded)
+		}
+		value := strings.TrimPrefix(unfolded, tc.key+":")
+		if strings.Join(strings.Fields(value), " ") != strings.Join(strings.Fields(tc.value), " ") {
+			t.Fatalf("%q unfolds to %q", tc.value, value)
+		}
+		if _, err := new(mime.WordDecoder).DecodeHeader(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
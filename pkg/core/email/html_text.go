@@ -0,0 +1,36 @@
+package email
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	htmlInvisibleRe = regexp.MustCompile(`(?is)<(head|script|style)[^>]*>.*?</(head|script|style)>`)
+	htmlLinkRe      = regexp.MustCompile(`(?is)<a\s[^>]*href\s*=\s*["']([^"']+)["'][^>]*>(.*?)</a>`)
+	htmlBreakRe     = regexp.MustCompile(`(?i)<br\s*/?>`)
+	htmlBlockEndRe  = regexp.MustCompile(`(?i)</(p|div|h[1-6]|tr|table|ul|ol|pre|blockquote)>`)
+	htmlListItemRe  = regexp.MustCompile(`(?i)<li[^>]*>`)
+	htmlTagRe       = regexp.MustCompile(`(?s)<[^>]*>`)
+	spacesRe        = regexp.MustCompile(`[ \t]+`)
+	emptyLinesRe    = regexp.MustCompile(`\n{3,}`)
+)
+
+// HTMLToText builds a plain-text fallback for an HTML body
+func HTMLToText(s string) string {
+	s = htmlInvisibleRe.ReplaceAllString(s, "")
+	s = htmlLinkRe.ReplaceAllString(s, "$2 ($1)")
+	s = htmlBreakRe.ReplaceAllString(s, "\n")
+	s = htmlBlockEndRe.ReplaceAllString(s, "\n\n")
+	s = htmlListItemRe.ReplaceAllString(s, "\n- ")
+	s = htmlTagRe.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+
+	lines := strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimSpace(spacesRe.ReplaceAllString(l, " "))
+	}
+	s = emptyLinesRe.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,223 @@
+package email
+
+import (
+	"errors"
+	"net/smtp"
+	"net/textproto"
+	"sync"
+	"time"
+)
+
+type pooledClient struct {
+	client   *smtp.Client
+	returned time.Time
+}
+
+type SendResult struct {
+	Message *Message
+	Err     error
+}
+
+// Pool reuses authenticated SMTP sessions between messages, issuing RSET instead of QUIT.
+// At most MaxConns sessions are open at once.
+type Pool struct {
+	Email       *Email
+	MaxConns    int
+	IdleTimeout time.Duration
+
+	sem    chan struct{}
+	lock   sync.Mutex
+	idle   []*pooledClient
+	closed bool
+	reaper *time.Ticker
+	// reaperStop ends the reap goroutine, Ticker.Stop doesn't close the ticker channel
+	reaperStop chan struct{}
+}
+
+func NewPool(e *Email, maxConns int, idleTimeout time.Duration) *Pool {
+	if maxConns <= 0 {
+		maxConns = 1
+	}
+	return &Pool{
+		Email:       e,
+		MaxConns:    maxConns,
+		IdleTimeout: idleTimeout,
+		sem:         make(chan struct{}, maxConns),
+	}
+}
+
+func (p *Pool) Send(m *Message) error {
+	if err := p.Email.Build(m); err != nil {
+		return err
+	}
+	from, rcpts, err := m.Envelope()
+	if err != nil {
+		return err
+	}
+	return p.SendRaw(from, rcpts, m.Bytes())
+}
+
+// SendRaw delivers an already built message over a pooled session
+func (p *Pool) SendRaw(from string, rcpts []string, data []byte) error {
+	p.sem <- struct{}{}
+	defer func() { <-p.sem }()
+
+	c, reused, err := p.get()
+	if err != nil {
+		return err
+	}
+	err = p.Email.deliver(c, from, rcpts, data)
+	if err == nil || isProtocolError(err) {
+		p.put(c)
+		return err
+	}
+	p.Email.close(c)
+
+	// An idle session may have been dropped by the server: reconnect and try once more,
+	// but only if the message data wasn't sent, otherwise the message could be delivered twice
+	var dataErr *DataSentError
+	if !reused || errors.As(err, &dataErr) {
+		return err
+	}
+	if c, err = p.Email.Connect(); err != nil {
+		return err
+	}
+	err = p.Email.deliver(c, from, rcpts, data)
+	if err == nil || isProtocolError(err) {
+		p.put(c)
+	} else {
+		p.Email.close(c)
+	}
+	return err
+}
+
+// SendBatch sends messages concurrently over pooled sessions and returns a result per message in the same order
+func (p *Pool) SendBatch(msgs []*Message) []*SendResult {
+	r := make([]*SendResult, len(msgs))
+	wg := sync.WaitGroup{}
+	for i, m := range msgs {
+		r[i] = &SendResult{Message: m}
+		wg.Add(1)
+		go func(res *SendResult) {
+			defer wg.Done()
+			res.Err = p.Send(res.Message)
+		}(r[i])
+	}
+	wg.Wait()
+	return r
+}
+
+func (p *Pool) Close() error {
+	p.lock.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	if p.reaper != nil {
+		p.reaper.Stop()
+		close(p.reaperStop)
+		p.reaper, p.reaperStop = nil, nil
+	}
+	p.lock.Unlock()
+
+	var err error
+	for _, pc := range idle {
+		if e := p.Email.quit(pc.client); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// get returns an idle session if there is one (reused is true then), otherwise a new one
+func (p *Pool) get() (c *smtp.Client, reused bool, err error) {
+	var expired []*pooledClient
+	p.lock.Lock()
+	for len(p.idle) > 0 {
+		pc := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if p.expired(pc) {
+			expired = append(expired, pc)
+			continue
+		}
+		c, reused = pc.client, true
+		break
+	}
+	p.lock.Unlock()
+
+	for _, pc := range expired {
+		p.Email.close(pc.client)
+	}
+	if c != nil {
+		return c, true, nil
+	}
+	c, err = p.Email.Connect()
+	return c, false, err
+}
+
+func (p *Pool) put(c *smtp.Client) {
+	p.Email.touch(c)
+	if c.Reset() != nil {
+		p.Email.close(c)
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		go p.Email.quit(c)
+		return
+	}
+	p.idle = append(p.idle, &pooledClient{client: c, returned: time.Now()})
+	if p.reaper == nil && p.IdleTimeout > 0 {
+		p.reaper = time.NewTicker(p.IdleTimeout)
+		p.reaperStop = make(chan struct{})
+		go p.reap(p.reaper, p.reaperStop)
+	}
+}
+
+func (p *Pool) reap(ticker *time.Ticker, stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		var expired []*pooledClient
+		p.lock.Lock()
+		if p.reaper != ticker {
+			p.lock.Unlock()
+			return
+		}
+		kept := p.idle[:0]
+		for _, pc := range p.idle {
+			if p.expired(pc) {
+				expired = append(expired, pc)
+			} else {
+				kept = append(kept, pc)
+			}
+		}
+		p.idle = kept
+		if len(p.idle) == 0 {
+			p.reaper.Stop()
+			p.reaper, p.reaperStop = nil, nil
+		}
+		p.lock.Unlock()
+
+		for _, pc := range expired {
+			p.Email.quit(pc.client)
+		}
+		if len(kept) == 0 {
+			return
+		}
+	}
+}
+
+func (p *Pool) expired(pc *pooledClient) bool {
+	return p.IdleTimeout > 0 && time.Since(pc.returned) >= p.IdleTimeout
+}
+
+// isProtocolError reports whether the server answered with an SMTP error code, i.e. the session is still usable
+func isProtocolError(err error) bool {
+	var tpErr *textproto.Error
+	return errors.As(err, &tpErr)
+}
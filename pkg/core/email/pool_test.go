@@ -0,0 +1,202 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// session is a capture server connection that records the commands of the client
+type session struct {
+	net.Conn
+	lock sync.Mutex
+	in   bytes.Buffer
+}
+
+func (s *session) Read(b []byte) (int, error) {
+	n, err := s.Conn.Read(b)
+	s.lock.Lock()
+	s.in.Write(b[:n])
+	s.lock.Unlock()
+	return n, err
+}
+
+// commands returns the verbs sent by the client, message data excluded
+func (s *session) commands() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var r []string
+	data := false
+	scanner := bufio.NewScanner(bytes.NewReader(s.in.Bytes()))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if data {
+			data = line != "."
+			continue
+		}
+		verb, _, _ := strings.Cut(line, " ")
+		verb = strings.ToUpper(verb)
+		data = verb == "DATA"
+		r = append(r, verb)
+	}
+	return r
+}
+
+type sessions struct {
+	lock sync.Mutex
+	list []*session
+}
+
+func (s *sessions) get() []*session {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]*session{}, s.list...)
+}
+
+// startRecordingCapture serves the capture server protocol on its own listener to see the sessions of the client
+func startRecordingCapture(t *testing.T) (*CaptureServer, *sessions) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewCaptureServer(l.Addr().String())
+	r := &sessions{}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			ss := &session{Conn: conn}
+			r.lock.Lock()
+			r.list = append(r.list, ss)
+			r.lock.Unlock()
+			go s.handle(ss)
+		}
+	}()
+	t.Cleanup(func() {
+		l.Close()
+		for _, ss := range r.get() {
+			ss.Close()
+		}
+	})
+	return s, r
+}
+
+func testMessage(subject string) *Message {
+	return &Message{From: "app@example.com", To: "bob@example.com", Subject: subject, BodyText: subject}
+}
+
+func expectCommands(t *testing.T, s *session, expected ...string) {
+	t.Helper()
+	// the server sees the commands of the client a bit after the client got its replies
+	deadline := time.Now().Add(time.Second)
+	for {
+		got := strings.Join(s.commands(), " ")
+		if got == strings.Join(expected, " ") {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("commands %v expected, got %v", expected, got)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPoolReusesSessions(t *testing.T) {
+	s, sessions := startRecordingCapture(t)
+	p := NewPool(New(s.Addr), 1, time.Minute)
+
+	for _, subject := range []string{"first", "second", "third"} {
+		if err := p.Send(testMessage(subject)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	messages := s.WaitFor(3, time.Second)
+	if len(messages) != 3 || messages[2].Subject != "third" {
+		t.Fatalf("3 messages expected, got %v", len(messages))
+	}
+	if n := len(sessions.get()); n != 1 {
+		t.Fatalf("the messages must share a session, %v sessions opened", n)
+	}
+	// every transaction is followed by RSET instead of QUIT
+	commands := []string{"EHLO"}
+	for range messages {
+		commands = append(commands, "MAIL", "RCPT", "DATA", "RSET")
+	}
+	expectCommands(t, sessions.get()[0], commands...)
+
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, sessions.get()[0], append(commands, "QUIT")...)
+}
+
+func TestPoolReconnectsDroppedSession(t *testing.T) {
+	s, sessions := startRecordingCapture(t)
+	p := NewPool(New(s.Addr), 1, time.Minute)
+	defer p.Close()
+
+	if err := p.Send(testMessage("first")); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, sessions.get()[0], "EHLO", "MAIL", "RCPT", "DATA", "RSET")
+	// the server drops the idle session
+	sessions.get()[0].Close()
+
+	if err := p.Send(testMessage("second")); err != nil {
+		t.Fatal(err)
+	}
+	if messages := s.WaitFor(2, time.Second); len(messages) != 2 || messages[1].Subject != "second" {
+		t.Fatalf("the message must be sent over a new session, got %v messages", len(messages))
+	}
+	if n := len(sessions.get()); n != 2 {
+		t.Fatalf("2 sessions expected, %v opened", n)
+	}
+}
+
+func TestPoolReapsIdleSessions(t *testing.T) {
+	s, sessions := startRecordingCapture(t)
+	p := NewPool(New(s.Addr), 1, 20*time.Millisecond)
+	defer p.Close()
+
+	if err := p.Send(testMessage("first")); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, sessions.get()[0], "EHLO", "MAIL", "RCPT", "DATA", "RSET", "QUIT")
+	expectNoReaper(t)
+}
+
+func TestPoolCloseStopsReaper(t *testing.T) {
+	s, _ := startRecordingCapture(t)
+	p := NewPool(New(s.Addr), 1, time.Hour)
+
+	if err := p.Send(testMessage("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expectNoReaper(t)
+}
+
+func expectNoReaper(t *testing.T) {
+	t.Helper()
+	buf := make([]byte, 1<<20)
+	deadline := time.Now().Add(time.Second)
+	for {
+		stacks := string(buf[:runtime.Stack(buf, true)])
+		if !strings.Contains(stacks, "(*Pool).reap") {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the reaper goroutine is still running:\n%v", stacks)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
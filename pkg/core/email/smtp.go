@@ -0,0 +1,163 @@
+package email
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+type TLSMode string
+
+const (
+	// TLSOpportunistic upgrades with STARTTLS when the server offers it
+	TLSOpportunistic TLSMode = "opportunistic"
+	// TLSStartTLS requires STARTTLS and fails if the server does not offer it
+	TLSStartTLS TLSMode = "starttls"
+	// TLSImplicit connects over TLS from the start (SMTPS, usually port 465)
+	TLSImplicit TLSMode = "implicit"
+	TLSNone     TLSMode = "none"
+)
+
+var ErrStartTLSNotOffered = errors.New("smtp: server does not offer STARTTLS")
+
+// Connect dials the server, negotiates TLS according to TLSMode and authenticates
+func (e *Email) Connect() (*smtp.Client, error) {
+	host, _, err := net.SplitHostPort(e.smtpAddress)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := e.tlsConfig(host)
+
+	dialer := &net.Dialer{Timeout: e.DialTimeout}
+	var conn net.Conn
+	if e.TLSMode == TLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", e.smtpAddress, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", e.smtpAddress)
+	}
+	if err != nil {
+		return nil, err
+	}
+	e.extendDeadline(conn)
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	e.conns.Store(c, conn)
+
+	if err = e.startTLS(c, tlsConfig); err != nil {
+		e.close(c)
+		return nil, err
+	}
+
+	if e.Auth != nil {
+		if err = c.Auth(e.Auth); err != nil {
+			e.close(c)
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (e *Email) startTLS(c *smtp.Client, tlsConfig *tls.Config) error {
+	switch e.TLSMode {
+	case TLSImplicit, TLSNone:
+		return nil
+	}
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		return c.StartTLS(tlsConfig)
+	}
+	if e.TLSMode == TLSStartTLS {
+		return ErrStartTLSNotOffered
+	}
+	return nil
+}
+
+func (e *Email) tlsConfig(host string) *tls.Config {
+	if e.TLSConfig == nil {
+		return &tls.Config{ServerName: host}
+	}
+	r := e.TLSConfig.Clone()
+	if len(r.ServerName) == 0 {
+		r.ServerName = host
+	}
+	return r
+}
+
+// extendDeadline applies IOTimeout to the next exchange with the server
+func (e *Email) extendDeadline(conn net.Conn) {
+	if e.IOTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(e.IOTimeout))
+	}
+}
+
+func (e *Email) touch(c *smtp.Client) {
+	if conn, ok := e.conns.Load(c); ok {
+		e.extendDeadline(conn.(net.Conn))
+	}
+}
+
+func (e *Email) close(c *smtp.Client) error {
+	e.conns.Delete(c)
+	return c.Close()
+}
+
+func (e *Email) quit(c *smtp.Client) error {
+	e.touch(c)
+	e.conns.Delete(c)
+	if err := c.Quit(); err != nil {
+		c.Close()
+		return err
+	}
+	return nil
+}
+
+type loginAuth struct {
+	username, password, host string
+}
+
+// LoginAuth implements the non-standard but widespread LOGIN mechanism (MS Exchange, Office 365).
+// Like smtp.PlainAuth it refuses to send credentials over an unencrypted connection to a remote host.
+func LoginAuth(username, password, host string) smtp.Auth {
+	return &loginAuth{username: username, password: password, host: host}
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("smtp: unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("smtp: wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, errors.New("smtp: unexpected LOGIN challenge: " + string(fromServer))
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+// IsPermanentError reports whether the server rejected the message with a 5xx reply,
+// so retrying the same message is pointless. Network failures and 4xx replies are temporary.
+func IsPermanentError(err error) bool {
+	var tpErr *textproto.Error
+	return errors.As(err, &tpErr) && tpErr.Code >= 500
+}
@@ -0,0 +1,313 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/itskovichanton/core/pkg/core/email"
+	"github.com/itskovichanton/goava/pkg/goava/errs"
+	"github.com/labstack/gommon/random"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultEmailQueueMaxAttempts  = 10
+	defaultEmailQueueMinBackoff   = time.Minute
+	defaultEmailQueueMaxBackoff   = time.Hour
+	defaultEmailQueuePollInterval = 30 * time.Second
+)
+
+type QueuedEmail struct {
+	ID            string    `json:"id"`
+	From          string    `json:"from"`
+	To            []string  `json:"to"`
+	Subject       string    `json:"subject"`
+	Data          []byte    `json:"data"`
+	Attempts      int       `json:"attempts"`
+	CreatedAt     time.Time `json:"createdAt"`
+	NextAttemptAt time.Time `json:"nextAttemptAt"`
+	LastError     string    `json:"lastError,omitempty"`
+}
+
+type EmailQueueStats struct {
+	Pending         int       `json:"pending"`
+	Failed          int       `json:"failed"`
+	Sent            int64     `json:"sent"`
+	Retried         int64     `json:"retried"`
+	Rejected        int64     `json:"rejected"`
+	OldestPendingAt time.Time `json:"oldestPendingAt,omitempty"`
+}
+
+// EmailQueue persists outgoing mail under Dir/pending and delivers it in the background.
+// Temporary failures (network errors, 4xx replies) are retried with exponential backoff,
+// permanent 5xx rejections and exhausted retries are moved to Dir/failed.
+// So are the messages that may have been delivered despite the error (see email.IsAmbiguousDelivery):
+// resending them could deliver duplicates.
+type EmailQueue struct {
+	Dir          string
+	Sender       func(from string, rcpts []string, data []byte) error
+	MaxAttempts  int
+	MinBackoff   time.Duration
+	MaxBackoff   time.Duration
+	PollInterval time.Duration
+	// Logger receives the errors of the queue itself, they are dropped when nil
+	Logger *log.Logger
+	// OnPanic receives panics of the delivery loop, the loop goes on after them
+	OnPanic func(err error)
+
+	lock                    sync.Mutex
+	wake                    chan struct{}
+	stop, done              chan struct{}
+	stopOnce                sync.Once
+	sent, retried, rejected int64
+}
+
+func (q *EmailQueue) Init() error {
+	if q.MaxAttempts <= 0 {
+		q.MaxAttempts = defaultEmailQueueMaxAttempts
+	}
+	if q.MinBackoff <= 0 {
+		q.MinBackoff = defaultEmailQueueMinBackoff
+	}
+	if q.MaxBackoff <= 0 {
+		q.MaxBackoff = defaultEmailQueueMaxBackoff
+	}
+	if q.PollInterval <= 0 {
+		q.PollInterval = defaultEmailQueuePollInterval
+	}
+	q.wake = make(chan struct{}, 1)
+	q.stop = make(chan struct{})
+	q.done = make(chan struct{})
+	if err := os.MkdirAll(q.pendingDir(), os.ModePerm); err != nil {
+		return err
+	}
+	return os.MkdirAll(q.failedDir(), os.ModePerm)
+}
+
+func (q *EmailQueue) Start() {
+	go q.run()
+}
+
+// Stop ends the delivery loop after the message being sent, pending messages stay on disk for the next start
+func (q *EmailQueue) Stop(ctx context.Context) error {
+	q.stopOnce.Do(func() { close(q.stop) })
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *EmailQueue) Enqueue(e *QueuedEmail) error {
+	if len(e.ID) == 0 {
+		e.ID = fmt.Sprintf("%d-%v", time.Now().UnixNano(), random.String(8))
+	}
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+		e.NextAttemptAt = e.CreatedAt
+	}
+	if err := q.save(q.pendingDir(), e); err != nil {
+		return err
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (q *EmailQueue) Stats() (*EmailQueueStats, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	pending, err := q.list(q.pendingDir())
+	if err != nil {
+		return nil, err
+	}
+	failed, err := q.list(q.failedDir())
+	if err != nil {
+		return nil, err
+	}
+	r := &EmailQueueStats{
+		Pending:  len(pending),
+		Failed:   len(failed),
+		Sent:     atomic.LoadInt64(&q.sent),
+		Retried:  atomic.LoadInt64(&q.retried),
+		Rejected: atomic.LoadInt64(&q.rejected),
+	}
+	for _, e := range pending {
+		if r.OldestPendingAt.IsZero() || e.CreatedAt.Before(r.OldestPendingAt) {
+			r.OldestPendingAt = e.CreatedAt
+		}
+	}
+	return r, nil
+}
+
+func (q *EmailQueue) run() {
+	defer close(q.done)
+	for {
+		next := q.processDueSafe()
+		wait := q.PollInterval
+		if !next.IsZero() && time.Until(next) < wait {
+			wait = time.Until(next)
+		}
+		select {
+		case <-q.stop:
+			return
+		case <-q.wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (q *EmailQueue) processDueSafe() (next time.Time) {
+	defer RecoverTo(q.onPanic)
+	return q.processDue()
+}
+
+func (q *EmailQueue) onPanic(err error) {
+	if q.OnPanic != nil {
+		q.OnPanic(err)
+	} else {
+		q.logError(err)
+	}
+}
+
+func (q *EmailQueue) logError(err error) {
+	if q.Logger != nil {
+		q.Logger.Println("email queue: " + err.Error())
+	}
+}
+
+// processDue sends every pending message whose time has come and returns the earliest next attempt time
+func (q *EmailQueue) processDue() time.Time {
+	q.lock.Lock()
+	pending, err := q.list(q.pendingDir())
+	q.lock.Unlock()
+	if err != nil {
+		q.logError(err)
+		return time.Time{}
+	}
+
+	var next time.Time
+	for _, e := range pending {
+		select {
+		case <-q.stop:
+			return next
+		default:
+		}
+		if time.Now().Before(e.NextAttemptAt) {
+			if next.IsZero() || e.NextAttemptAt.Before(next) {
+				next = e.NextAttemptAt
+			}
+			continue
+		}
+		if err = q.process(e); err != nil {
+			q.logError(err)
+		}
+		if !e.NextAttemptAt.IsZero() && (next.IsZero() || e.NextAttemptAt.Before(next)) {
+			next = e.NextAttemptAt
+		}
+	}
+	return next
+}
+
+func (q *EmailQueue) process(e *QueuedEmail) error {
+	sendErr := q.Sender(e.From, e.To, e.Data)
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if sendErr == nil {
+		atomic.AddInt64(&q.sent, 1)
+		e.NextAttemptAt = time.Time{}
+		return os.Remove(q.fileName(q.pendingDir(), e.ID))
+	}
+
+	e.Attempts++
+	e.LastError = sendErr.Error()
+	if email.IsPermanentError(sendErr) || email.IsAmbiguousDelivery(sendErr) || e.Attempts >= q.MaxAttempts {
+		atomic.AddInt64(&q.rejected, 1)
+		e.NextAttemptAt = time.Time{}
+		if err := q.save(q.failedDir(), e); err != nil {
+			return err
+		}
+		return os.Remove(q.fileName(q.pendingDir(), e.ID))
+	}
+
+	atomic.AddInt64(&q.retried, 1)
+	e.NextAttemptAt = time.Now().Add(q.backoff(e.Attempts))
+	return q.save(q.pendingDir(), e)
+}
+
+func (q *EmailQueue) backoff(attempts int) time.Duration {
+	r := q.MinBackoff
+	for i := 1; i < attempts && r < q.MaxBackoff; i++ {
+		r *= 2
+	}
+	if r > q.MaxBackoff {
+		r = q.MaxBackoff
+	}
+	return r
+}
+
+// save writes the message atomically so that a crash never leaves a half-written file in the queue
+func (q *EmailQueue) save(dir string, e *QueuedEmail) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	fileName := q.fileName(dir, e.ID)
+	tmpFileName := fileName + ".tmp"
+	if err = os.WriteFile(tmpFileName, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, fileName)
+}
+
+func (q *EmailQueue) list(dir string) ([]*QueuedEmail, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var r []*QueuedEmail
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var e QueuedEmail
+		if err = json.Unmarshal(data, &e); err != nil {
+			q.logError(errs.NewBaseErrorFromCauseMsg(err, "skipping "+entry.Name()+": "+err.Error()))
+			continue
+		}
+		r = append(r, &e)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].CreatedAt.Before(r[j].CreatedAt)
+	})
+	return r, nil
+}
+
+func (q *EmailQueue) fileName(dir, id string) string {
+	return filepath.Join(dir, id+".json")
+}
+
+func (q *EmailQueue) pendingDir() string {
+	return filepath.Join(q.Dir, "pending")
+}
+
+func (q *EmailQueue) failedDir() string {
+	return filepath.Join(q.Dir, "failed")
+}
@@ -0,0 +1,189 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"github.com/itskovichanton/core/pkg/core/email"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newCapturedQueue returns a queue over dir delivering through a pool to a capture server,
+// fail decides the outcome of each attempt before it reaches the server
+func newCapturedQueue(t *testing.T, dir string, fail func(attempt int) error) (*EmailQueue, *email.CaptureServer) {
+	s := email.NewCaptureServer("")
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	pool := email.NewPool(email.New(s.Addr), 1, time.Minute)
+	t.Cleanup(func() {
+		pool.Close()
+		s.Close()
+	})
+
+	var lock sync.Mutex
+	attempts := 0
+	q := &EmailQueue{
+		Dir:          dir,
+		MinBackoff:   10 * time.Millisecond,
+		MaxBackoff:   40 * time.Millisecond,
+		MaxAttempts:  3,
+		PollInterval: time.Hour,
+		Sender: func(from string, rcpts []string, data []byte) error {
+			lock.Lock()
+			attempts++
+			n := attempts
+			lock.Unlock()
+			if fail != nil {
+				if err := fail(n); err != nil {
+					return err
+				}
+			}
+			return pool.SendRaw(from, rcpts, data)
+		},
+	}
+	if err := q.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return q, s
+}
+
+func queuedEmail(t *testing.T, subject string) *QueuedEmail {
+	m := &email.Message{From: "app@example.com", To: "bob@example.com", Subject: subject, BodyText: subject}
+	if err := email.New("").Build(m); err != nil {
+		t.Fatal(err)
+	}
+	from, rcpts, err := m.Envelope()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &QueuedEmail{From: from, To: rcpts, Subject: subject, Data: m.Bytes()}
+}
+
+func waitQueueStats(t *testing.T, q *EmailQueue, done func(s *EmailQueueStats) bool) *EmailQueueStats {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		s, err := q.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if done(s) {
+			return s
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected queue stats %+v", s)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestEmailQueuePersistence(t *testing.T) {
+	dir := t.TempDir()
+
+	// the messages enqueued while the queue isn't running stay on disk for the next start
+	stopped, _ := newCapturedQueue(t, dir, nil)
+	for _, subject := range []string{"first", "second"} {
+		if err := stopped.Enqueue(queuedEmail(t, subject)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s, err := stopped.Stats(); err != nil || s.Pending != 2 {
+		t.Fatalf("2 pending messages expected, got %+v, %v", s, err)
+	}
+
+	q, capture := newCapturedQueue(t, dir, nil)
+	q.Start()
+	waitQueueStats(t, q, func(s *EmailQueueStats) bool { return s.Sent == 2 && s.Pending == 0 })
+	messages := capture.WaitFor(2, time.Second)
+	if len(messages) != 2 || messages[0].Subject != "first" || messages[1].Subject != "second" {
+		t.Fatalf("the messages must be sent in the order they were enqueued, got %v", len(messages))
+	}
+	if err := q.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEmailQueueRetriesTemporaryErrors(t *testing.T) {
+	var attemptedAt []time.Time
+	q, capture := newCapturedQueue(t, t.TempDir(), func(attempt int) error {
+		attemptedAt = append(attemptedAt, time.Now())
+		if attempt <= 2 {
+			return &textproto.Error{Code: 421, Msg: "try again later"}
+		}
+		return nil
+	})
+	q.Start()
+	defer q.Stop(context.Background())
+	if err := q.Enqueue(queuedEmail(t, "hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	s := waitQueueStats(t, q, func(s *EmailQueueStats) bool { return s.Sent == 1 })
+	if s.Retried != 2 || s.Pending != 0 || s.Failed != 0 {
+		t.Fatalf("unexpected queue stats %+v", s)
+	}
+	if messages := capture.WaitFor(1, time.Second); len(messages) != 1 || messages[0].Subject != "hello" {
+		t.Fatal("the message wasn't delivered")
+	}
+	// the backoff doubles after each failure
+	for i, min := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond} {
+		if d := attemptedAt[i+1].Sub(attemptedAt[i]); d < min {
+			t.Fatalf("attempt %v came %v after the previous one, %v expected at least", i+2, d, min)
+		}
+	}
+}
+
+func TestEmailQueueBackoff(t *testing.T) {
+	q := &EmailQueue{MinBackoff: time.Minute, MaxBackoff: 5 * time.Minute}
+	for attempts, expected := range []time.Duration{time.Minute, time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute} {
+		if r := q.backoff(attempts); r != expected {
+			t.Fatalf("%v attempts: %v expected, got %v", attempts, expected, r)
+		}
+	}
+}
+
+func TestEmailQueueMovesToFailed(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		err      error
+		attempts int
+	}{
+		{"permanent", &textproto.Error{Code: 550, Msg: "no such user"}, 1},
+		{"ambiguous", &email.DataSentError{Err: errors.New("connection reset")}, 1},
+		{"exhausted", &textproto.Error{Code: 451, Msg: "try again later"}, 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			q, capture := newCapturedQueue(t, dir, func(int) error { return tc.err })
+			q.Start()
+			defer q.Stop(context.Background())
+			e := queuedEmail(t, "hello")
+			if err := q.Enqueue(e); err != nil {
+				t.Fatal(err)
+			}
+
+			s := waitQueueStats(t, q, func(s *EmailQueueStats) bool { return s.Failed == 1 })
+			if s.Pending != 0 || s.Rejected != 1 || s.Sent != 0 {
+				t.Fatalf("unexpected queue stats %+v", s)
+			}
+			failed, err := q.list(filepath.Join(dir, "failed"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(failed) != 1 || failed[0].ID != e.ID || failed[0].Attempts != tc.attempts || failed[0].LastError != tc.err.Error() {
+				t.Fatalf("unexpected failed message %+v", failed[0])
+			}
+			if _, err = os.Stat(filepath.Join(dir, "pending", e.ID+".json")); !os.IsNotExist(err) {
+				t.Fatalf("the failed message must be removed from pending, %v", err)
+			}
+			if n := len(capture.Messages()); n != 0 {
+				t.Fatalf("no messages expected, %v captured", n)
+			}
+		})
+	}
+}
@@ -1,98 +1,553 @@
 package core
 
 import (
-	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"github.com/itskovichanton/core/pkg/core/email"
-	"html/template"
+	"github.com/itskovichanton/core/pkg/core/i18n"
+	"github.com/itskovichanton/core/pkg/core/validation"
+	"github.com/itskovichanton/goava/pkg/goava/errs"
+	"github.com/itskovichanton/goava/pkg/goava/utils"
+	"github.com/spf13/cast"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
 	"net/smtp"
+	"os"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
+const (
+	ReasonAttachmentsTooLarge = "ATTACHMENTS_TOO_LARGE"
+
+	defaultEmailMaxAttachmentsSize = 25 * 1024 * 1024
+
+	headerNamePattern = `^[!-9;-~]+$`
+
+	defaultEmailDialTimeout = 30 * time.Second
+	defaultEmailIOTimeout   = 2 * time.Minute
+
+	defaultEmailPoolMaxConns    = 4
+	defaultEmailPoolIdleTimeout = 30 * time.Second
+)
+
+var reservedHeaders = []string{
+	"From", "To", "Cc", "Bcc", "Reply-To", "Subject", "Date", "Message-ID",
+	"MIME-Version", "Content-Type", "Content-Transfer-Encoding",
+}
+
 type IEmailService interface {
 	Send(p *Params) error
+	SendBatch(ps []*Params) []*SendResult
+	// SendCtx and SendBatchCtx render templates without Template.Locale in the locale of ctx (see i18n.WithLocale)
+	SendCtx(ctx context.Context, p *Params) error
+	SendBatchCtx(ctx context.Context, ps []*Params) []*SendResult
+	// GetQueueStats returns nil when the queued mode is off
+	GetQueueStats() (*EmailQueueStats, error)
+}
+
+type SendResult struct {
+	Params *Params
+	Err    error
 }
 
 type EmailServiceImpl struct {
 	IEmailService
 
-	Config *Config
+	Config    *Config
+	Templates IEmailTemplateRegistry
+	// Capture is the in-process SMTP sink used instead of the relay when email.capture.enabled is set
+	Capture *email.CaptureServer
+	// Logger receives the errors of the background queue, they are dropped when nil
+	Logger *log.Logger
+	// OnQueuePanic receives panics of the background queue
+	OnQueuePanic func(err error)
+
+	address     string
+	auth        smtp.Auth
+	tlsMode     email.TLSMode
+	tlsConfig   *tls.Config
+	dialTimeout time.Duration
+	ioTimeout   time.Duration
+	dkim        *email.DKIMSigner
+	pool        *email.Pool
+	queue       *EmailQueue
+}
+
+func (r *EmailServiceImpl) Init() error {
+	var err error
+	if r.Templates == nil {
+		// TemplateFileName still needs a registry to cache the parsed files
+		templates := &EmailTemplateRegistryImpl{Logger: r.Logger}
+		if err = templates.Init(); err != nil {
+			return err
+		}
+		r.Templates = templates
+	}
+	address := r.Config.GetStr("email", "address")
+	host := r.Config.GetStr("email", "host")
+	if len(host) == 0 && len(address) > 0 {
+		if host, _, err = net.SplitHostPort(address); err != nil {
+			return errs.NewBaseErrorFromCauseMsg(err, "email.address: "+err.Error())
+		}
+	}
+
+	r.tlsMode = email.TLSMode(strings.ToLower(r.Config.GetStr("email", "tls")))
+	switch r.tlsMode {
+	case "":
+		r.tlsMode = email.TLSOpportunistic
+		if strings.HasSuffix(address, ":465") {
+			r.tlsMode = email.TLSImplicit
+		}
+	case email.TLSOpportunistic, email.TLSStartTLS, email.TLSImplicit, email.TLSNone:
+	default:
+		return errs.NewBaseError("email.tls: unknown mode " + string(r.tlsMode))
+	}
+
+	r.tlsConfig = &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: r.Config.GetBool("email", "insecureskipverify"),
+	}
+	if caFile := r.Config.GetStr("email", "cafile"); len(caFile) > 0 {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return errs.NewBaseErrorFromCauseMsg(err, "email.caFile: "+err.Error())
+		}
+		r.tlsConfig.RootCAs = x509.NewCertPool()
+		if !r.tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return errs.NewBaseError("email.caFile: no certificates found in " + caFile)
+		}
+	}
+
+	if r.dialTimeout, err = r.getDuration(defaultEmailDialTimeout, "email", "dialtimeout"); err != nil {
+		return err
+	}
+	if r.ioTimeout, err = r.getDuration(defaultEmailIOTimeout, "email", "iotimeout"); err != nil {
+		return err
+	}
+
+	username := r.Config.GetStr("email", "username")
+	password := r.Config.GetStr("email", "password")
+	switch mechanism := strings.ToLower(r.Config.GetStr("email", "auth")); mechanism {
+	case "":
+		if len(username) > 0 {
+			r.auth = smtp.PlainAuth("", username, password, host)
+		}
+	case "plain":
+		r.auth = smtp.PlainAuth("", username, password, host)
+	case "login":
+		r.auth = email.LoginAuth(username, password, host)
+	case "cram-md5":
+		r.auth = smtp.CRAMMD5Auth(username, password)
+	case "none":
+	default:
+		return errs.NewBaseError("email.auth: unknown mechanism " + mechanism)
+	}
+
+	if err = r.initDKIM(); err != nil {
+		return err
+	}
+
+	r.address = address
+	if r.Config.GetBool("email", "capture", "enabled") {
+		if err = r.initCapture(); err != nil {
+			return err
+		}
+	}
+
+	maxConns := r.Config.GetInt("email", "pool", "maxconns")
+	if maxConns <= 0 {
+		maxConns = defaultEmailPoolMaxConns
+	}
+	idleTimeout, err := r.getDuration(defaultEmailPoolIdleTimeout, "email", "pool", "idletimeout")
+	if err != nil {
+		return err
+	}
+	r.pool = email.NewPool(r.newEmail(), maxConns, idleTimeout)
+
+	if r.Config.GetBool("email", "queue", "enabled") {
+		return r.initQueue()
+	}
+	return nil
+}
+
+func (r *EmailServiceImpl) initCapture() error {
+	r.Capture = email.NewCaptureServer(r.Config.GetStr("email", "capture", "address"))
+	if err := r.Capture.Start(); err != nil {
+		return errs.NewBaseErrorFromCauseMsg(err, "email.capture: "+err.Error())
+	}
+	r.address = r.Capture.Addr
+	r.tlsMode = email.TLSNone
+	r.auth = nil
+	return nil
+}
+
+func (r *EmailServiceImpl) initDKIM() error {
+	keyFile := r.Config.GetStr("email", "dkim", "keyfile")
+	if len(keyFile) == 0 {
+		return nil
+	}
+	key, err := email.LoadDKIMKey(keyFile)
+	if err != nil {
+		return errs.NewBaseErrorFromCauseMsg(err, "email.dkim.keyFile: "+err.Error())
+	}
+	r.dkim = &email.DKIMSigner{
+		Domain:   r.Config.GetStr("email", "dkim", "domain"),
+		Selector: r.Config.GetStr("email", "dkim", "selector"),
+		Key:      key,
+		Headers:  cast.ToStringSlice(r.Config.Get("email", "dkim", "headers")),
+	}
+	if len(r.dkim.Domain) == 0 || len(r.dkim.Selector) == 0 {
+		return errs.NewBaseError("email.dkim: domain and selector are required")
+	}
+	if c := r.Config.GetStr("email", "dkim", "canonicalization"); len(c) > 0 {
+		if r.dkim.HeaderCanonicalization, r.dkim.BodyCanonicalization, err = email.ParseCanonicalization(c); err != nil {
+			return errs.NewBaseErrorFromCauseMsg(err, "email.dkim.canonicalization: "+err.Error())
+		}
+	}
+	return nil
+}
+
+func (r *EmailServiceImpl) initQueue() error {
+	r.queue = &EmailQueue{
+		Dir:         r.Config.GetDir("mailqueue"),
+		Sender:      r.pool.SendRaw,
+		MaxAttempts: r.Config.GetInt("email", "queue", "maxattempts"),
+		Logger:      r.Logger,
+		OnPanic: func(err error) {
+			if r.OnQueuePanic != nil {
+				r.OnQueuePanic(err)
+			} else if r.Logger != nil {
+				r.Logger.Println("email queue: " + err.Error())
+			}
+		},
+	}
+	var err error
+	if r.queue.MinBackoff, err = r.getDuration(defaultEmailQueueMinBackoff, "email", "queue", "minbackoff"); err != nil {
+		return err
+	}
+	if r.queue.MaxBackoff, err = r.getDuration(defaultEmailQueueMaxBackoff, "email", "queue", "maxbackoff"); err != nil {
+		return err
+	}
+	if err = r.queue.Init(); err != nil {
+		return err
+	}
+	r.queue.Start()
+	return nil
+}
+
+// Stop ends the background queue and closes the pooled sessions and the capture server
+func (r *EmailServiceImpl) Stop(ctx context.Context) error {
+	var err error
+	if r.queue != nil {
+		err = r.queue.Stop(ctx)
+	}
+	if r.pool != nil {
+		if e := r.pool.Close(); err == nil {
+			err = e
+		}
+	}
+	if r.Capture != nil {
+		if e := r.Capture.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (r *EmailServiceImpl) GetQueueStats() (*EmailQueueStats, error) {
+	if r.queue == nil {
+		return nil, nil
+	}
+	return r.queue.Stats()
+}
+
+func (r *EmailServiceImpl) getDuration(defaultValue time.Duration, path ...string) (time.Duration, error) {
+	v := r.Config.Get(path...)
+	if v == nil {
+		return defaultValue, nil
+	}
+	d, err := cast.ToDurationE(v)
+	if err != nil {
+		return 0, errs.NewBaseErrorFromCauseMsg(err, strings.Join(path, ".")+": "+err.Error())
+	}
+	return d, nil
+}
+
+func (r *EmailServiceImpl) newEmail() *email.Email {
+	e := email.New(r.address)
+	e.Auth = r.auth
+	e.TLSMode = r.tlsMode
+	e.TLSConfig = r.tlsConfig
+	e.DialTimeout = r.dialTimeout
+	e.IOTimeout = r.ioTimeout
+	e.DKIM = r.dkim
+	return e
 }
 
 type Params struct {
 	From                string
 	To                  []string
+	CC                  []string
+	BCC                 []string
+	ReplyTo             string
+	Priority            email.Priority
+	Headers             map[string]string
 	Subject             string
 	Body                string
 	Template            *Template
 	AttachmentFileNames []string
+	Attachments         []*Attachment
+	// Inlines are referenced from the HTML template by cid:<Name>
+	Inlines []*Attachment
+}
+
+type Attachment struct {
+	Name   string
+	Type   string
+	Reader io.Reader
 }
 
 type Template struct {
+	// Name of a template in IEmailTemplateRegistry, takes precedence over TemplateFileName
+	Name string
+	// Locale is the locale of the context passed to SendCtx when empty
+	Locale           string
 	TemplateFileName string
 	Data             interface{}
 }
 
-func (r *Params) parseTemplate(templateFileName string, data interface{}) error {
-	t, err := template.ParseFiles(templateFileName)
-	if err != nil {
+func (r *Params) Validate() error {
+	if _, err := validation.CheckEmail("from", r.From); err != nil {
+		return err
+	}
+	if _, err := validation.CheckConditionKey(func() (interface{}, bool) {
+		return r.To, len(r.To)+len(r.CC)+len(r.BCC) > 0
+	}, "to", validation.Empty, r.To, "validation.noRecipients"); err != nil {
+		return err
+	}
+	if err := checkEmails("to", r.To); err != nil {
 		return err
 	}
-	buf := new(bytes.Buffer)
-	if err = t.Execute(buf, data); err != nil {
+	if err := checkEmails("cc", r.CC); err != nil {
 		return err
 	}
-	r.Body = buf.String()
+	if err := checkEmails("bcc", r.BCC); err != nil {
+		return err
+	}
+	if len(r.ReplyTo) > 0 {
+		if _, err := validation.CheckEmail("replyTo", r.ReplyTo); err != nil {
+			return err
+		}
+	}
+	for k, v := range r.Headers {
+		if _, err := validation.CheckMatchRegexp("headers", k, headerNamePattern); err != nil {
+			return err
+		}
+		if _, err := validation.CheckConditionKey(func() (interface{}, bool) {
+			return k, !utils.ContainsStr(reservedHeaders, k, true)
+		}, "headers", validation.Unexpectable, k, "validation.reservedHeader", k); err != nil {
+			return err
+		}
+		if _, err := validation.CheckConditionKey(func() (interface{}, bool) {
+			return v, !strings.ContainsAny(v, "\r\n")
+		}, "headers."+k, validation.Unexpectable, v, "validation.headerNewline"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkEmails(param string, addrs []string) error {
+	for i, addr := range addrs {
+		if _, err := validation.CheckEmail(fmt.Sprintf("%v[%v]", param, i), addr); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 func (r *EmailServiceImpl) Send(p *Params) error {
+	return r.SendCtx(context.Background(), p)
+}
 
-	var tmpl *template.Template
-	var err error
-	var wr *bytes.Buffer
+func (r *EmailServiceImpl) SendCtx(ctx context.Context, p *Params) error {
+	msg, err := r.buildMessage(ctx, p)
+	if err != nil {
+		return err
+	}
+	if r.queue != nil {
+		return r.enqueue(msg)
+	}
+	return r.pool.Send(msg)
+}
+
+func (r *EmailServiceImpl) enqueue(msg *email.Message) error {
+	if err := r.pool.Email.Build(msg); err != nil {
+		return err
+	}
+	from, rcpts, err := msg.Envelope()
+	if err != nil {
+		return err
+	}
+	return r.queue.Enqueue(&QueuedEmail{
+		From:    from,
+		To:      rcpts,
+		Subject: msg.Subject,
+		Data:    msg.Bytes(),
+	})
+}
+
+func (r *EmailServiceImpl) SendBatch(ps []*Params) []*SendResult {
+	return r.SendBatchCtx(context.Background(), ps)
+}
 
-	if p.Template != nil {
-		tmpl, err = template.ParseFiles(p.Template.TemplateFileName)
+func (r *EmailServiceImpl) SendBatchCtx(ctx context.Context, ps []*Params) []*SendResult {
+	results := make([]*SendResult, len(ps))
+	if r.queue != nil {
+		for i, p := range ps {
+			results[i] = &SendResult{Params: p, Err: r.SendCtx(ctx, p)}
+		}
+		return results
+	}
+
+	var msgs []*email.Message
+	var sent []*SendResult
+	for i, p := range ps {
+		results[i] = &SendResult{Params: p}
+		msg, err := r.buildMessage(ctx, p)
 		if err != nil {
-			tmpl = nil
-			//p.Body = p.
-		} else {
-			wr = bytes.NewBuffer(make([]byte, 256))
-			err = tmpl.Execute(wr, p.Template.Data)
-			if err != nil {
-				wr = nil
-				tmpl = nil
-			}
+			results[i].Err = err
+			continue
 		}
-	} else {
-		tmpl = nil
+		msgs = append(msgs, msg)
+		sent = append(sent, results[i])
+	}
+	for i, res := range r.pool.SendBatch(msgs) {
+		sent[i].Err = res.Err
+	}
+	return results
+}
+
+func (r *EmailServiceImpl) buildMessage(ctx context.Context, p *Params) (*email.Message, error) {
+
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	rendered, err := r.renderTemplate(ctx, p.Template)
+	if err != nil {
+		return nil, err
 	}
 
-	emailsvc := email.New(r.Config.GetStr("email", "address"))
-	emailsvc.Auth = smtp.PlainAuth("",
-		r.Config.GetStr("email", "username"),
-		r.Config.GetStr("email", "password"),
-		r.Config.GetStr("email", "host"),
-	)
-	emailsvc.Header = map[string]string{
-		"Content-Type": "multipart/mixed; charset=UTF-8",
+	attachments, inlines, err := r.readAttachments(p)
+	if err != nil {
+		return nil, err
 	}
-	emailsvc.Template = tmpl
 
-	msg := email.Message{
+	msg := &email.Message{
 		From:        p.From,
 		To:          strings.Join(p.To, ","),
-		CC:          "",
+		CC:          strings.Join(p.CC, ","),
+		BCC:         strings.Join(p.BCC, ","),
+		ReplyTo:     p.ReplyTo,
+		Priority:    p.Priority,
+		Header:      p.Headers,
 		Subject:     p.Subject,
-		Inlines:     nil,
-		Attachments: nil,
+		BodyText:    p.Body,
+		Inlines:     inlines,
+		Attachments: attachments,
 	}
 
-	if wr != nil {
-		msg.BodyHTML = wr.String()
-	} else {
-		msg.BodyText = p.Body
+	if rendered != nil {
+		msg.BodyHTML = rendered.HTML
+		if len(msg.Subject) == 0 {
+			msg.Subject = rendered.Subject
+		}
+		if len(msg.BodyText) == 0 {
+			msg.BodyText = rendered.Text
+		}
 	}
 
-	return emailsvc.Send(&msg)
+	return msg, nil
+}
+
+func (r *EmailServiceImpl) renderTemplate(ctx context.Context, t *Template) (*RenderedTemplate, error) {
+	if t == nil {
+		return nil, nil
+	}
+	locale := t.Locale
+	if len(locale) == 0 {
+		locale = i18n.GetLocale(ctx)
+	}
+	if len(t.Name) > 0 {
+		return r.Templates.Render(t.Name, locale, t.Data)
+	}
+	return r.Templates.RenderFile(t.TemplateFileName, locale, t.Data)
+}
+
+func (r *EmailServiceImpl) readAttachments(p *Params) (attachments, inlines []*email.File, err error) {
+	maxSize := uint64(defaultEmailMaxAttachmentsSize)
+	if s := r.Config.GetStr("email", "maxattachmentssize"); len(s) > 0 {
+		if maxSize, err = utils.ParseMemory(s); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var total uint64
+	add := func(files *[]*email.File, name, contentType string, reader io.Reader) error {
+		content, err := io.ReadAll(io.LimitReader(reader, int64(maxSize-total)+1))
+		if err != nil {
+			return err
+		}
+		total += uint64(len(content))
+		if total > maxSize {
+			return errs.NewBaseErrorWithReason(fmt.Sprintf("attachments exceed %v", utils.ByteCountIEC(maxSize)), ReasonAttachmentsTooLarge)
+		}
+		if len(contentType) == 0 {
+			contentType = detectContentType(name, content)
+		}
+		*files = append(*files, &email.File{
+			Name: filepath.Base(name),
+			Type: contentType,
+			Data: content,
+		})
+		return nil
+	}
+
+	for _, fileName := range p.AttachmentFileNames {
+		f, err := os.Open(fileName)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = add(&attachments, fileName, "", f)
+		f.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, a := range p.Attachments {
+		if err = add(&attachments, a.Name, a.Type, a.Reader); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, a := range p.Inlines {
+		if err = add(&inlines, a.Name, a.Type, a.Reader); err != nil {
+			return nil, nil, err
+		}
+	}
+	return attachments, inlines, nil
+}
+
+func detectContentType(fileName string, content []byte) string {
+	if r := mime.TypeByExtension(filepath.Ext(fileName)); len(r) > 0 {
+		return r
+	}
+	return http.DetectContentType(content)
 }
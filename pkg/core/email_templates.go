@@ -0,0 +1,344 @@
+package core
+
+import (
+	"bytes"
+	"github.com/itskovichanton/core/pkg/core/i18n"
+	"github.com/itskovichanton/goava/pkg/goava/errs"
+	"html"
+	"html/template"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ReasonTemplateNotFound = "TEMPLATE_NOT_FOUND"
+
+	emailTemplateSubject = "subject"
+	emailTemplateText    = "text"
+
+	emailTemplateMessageFunc = "t"
+
+	defaultEmailTemplatesReloadInterval = 2 * time.Second
+)
+
+type RenderedTemplate struct {
+	Subject string
+	HTML    string
+	Text    string
+}
+
+type IEmailTemplateRegistry interface {
+	Render(name, locale string, data interface{}) (*RenderedTemplate, error)
+	// RenderFile renders a template file outside Dir, it is parsed on first use and again when the file changes
+	RenderFile(fileName, locale string, data interface{}) (*RenderedTemplate, error)
+	// Has reports whether a template or any of its locale variants is loaded
+	Has(name string) bool
+	Reload() error
+}
+
+// EmailTemplateRegistryImpl loads templates from Dir once and reloads them when the files change.
+//
+// Files in Dir/layouts and Dir/partials are shared by all templates. Any other *.html file is a template
+// named by its path relative to Dir without extension; "welcome.ru.html" is the "ru" variant of "welcome".
+// A template may define "subject" and "text" blocks for the subject and the plain-text body.
+// {{t "key" args...}} renders a message of the Messages catalog in the locale of the email.
+type EmailTemplateRegistryImpl struct {
+	IEmailTemplateRegistry
+
+	Dir            string
+	DefaultLocale  string
+	ReloadInterval time.Duration
+	// Messages is i18n.Default when nil
+	Messages *i18n.Catalog
+	// Logger receives reload failures, they are dropped when nil
+	Logger *log.Logger
+
+	lock        sync.RWMutex
+	base        *template.Template
+	templates   map[string]*template.Template
+	files       map[string]*fileTemplate
+	modTime     time.Time
+	lastCheckAt time.Time
+}
+
+type fileTemplate struct {
+	t         *template.Template
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+func (c *EmailTemplateRegistryImpl) Init() error {
+	if c.ReloadInterval == 0 {
+		c.ReloadInterval = defaultEmailTemplatesReloadInterval
+	}
+	return c.Reload()
+}
+
+func (c *EmailTemplateRegistryImpl) Render(name, locale string, data interface{}) (*RenderedTemplate, error) {
+	c.reloadIfChanged()
+
+	t, err := c.lookup(name, locale)
+	if err != nil {
+		return nil, err
+	}
+	return c.render(t, locale, data)
+}
+
+func (c *EmailTemplateRegistryImpl) RenderFile(fileName, locale string, data interface{}) (*RenderedTemplate, error) {
+	c.reloadIfChanged()
+
+	t, err := c.fileTemplate(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return c.render(t, locale, data)
+}
+
+func (c *EmailTemplateRegistryImpl) Has(name string) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for key := range c.templates {
+		if key == name || strings.HasPrefix(key, name+".") && !strings.Contains(key[len(name)+1:], ".") {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *EmailTemplateRegistryImpl) render(t *template.Template, locale string, data interface{}) (*RenderedTemplate, error) {
+	t, err := c.localize(t, locale)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &RenderedTemplate{}
+	buf := &bytes.Buffer{}
+	if err = t.Execute(buf, data); err != nil {
+		return nil, err
+	}
+	r.HTML = buf.String()
+	if sub := t.Lookup(emailTemplateSubject); sub != nil {
+		buf.Reset()
+		if err = sub.Execute(buf, data); err != nil {
+			return nil, err
+		}
+		r.Subject = strings.TrimSpace(html.UnescapeString(buf.String()))
+	}
+	if text := t.Lookup(emailTemplateText); text != nil {
+		buf.Reset()
+		if err = text.Execute(buf, data); err != nil {
+			return nil, err
+		}
+		r.Text = strings.TrimSpace(html.UnescapeString(buf.String()))
+	}
+	return r, nil
+}
+
+// localize binds the "t" function of a copy of the template to locale
+func (c *EmailTemplateRegistryImpl) localize(t *template.Template, locale string) (*template.Template, error) {
+	if len(locale) == 0 {
+		locale = c.DefaultLocale
+	}
+	messages := c.Messages
+	if messages == nil {
+		messages = i18n.Default
+	}
+	r, err := t.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return r.Funcs(template.FuncMap{
+		emailTemplateMessageFunc: func(key string, args ...interface{}) string {
+			return messages.Message(locale, key, args...)
+		},
+	}), nil
+}
+
+func (c *EmailTemplateRegistryImpl) lookup(name, locale string) (*template.Template, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	for _, l := range i18n.Fallbacks(locale, c.DefaultLocale) {
+		if t, ok := c.templates[name+"."+l]; ok {
+			return t, nil
+		}
+	}
+	if t, ok := c.templates[name]; ok {
+		return t, nil
+	}
+	return nil, errs.NewBaseErrorWithReason("email template not found: "+name, ReasonTemplateNotFound)
+}
+
+// fileTemplate returns the cached template of fileName, parsing it again once it has changed
+func (c *EmailTemplateRegistryImpl) fileTemplate(fileName string) (*template.Template, error) {
+	c.lock.RLock()
+	cached := c.files[fileName]
+	base := c.base
+	c.lock.RUnlock()
+	if cached != nil && (c.ReloadInterval < 0 || time.Since(cached.checkedAt) < c.ReloadInterval) {
+		return cached.t, nil
+	}
+
+	info, err := os.Stat(fileName)
+	if os.IsNotExist(err) {
+		return nil, errs.NewBaseErrorWithReason("email template not found: "+fileName, ReasonTemplateNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r := &fileTemplate{modTime: info.ModTime(), checkedAt: time.Now()}
+	if cached != nil && !info.ModTime().After(cached.modTime) {
+		r.t = cached.t
+	} else {
+		if base == nil {
+			base = newEmailTemplateBase()
+		}
+		t, err := base.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if t, err = t.ParseFiles(fileName); err != nil {
+			return nil, err
+		}
+		r.t = t.Lookup(filepath.Base(fileName))
+	}
+
+	c.lock.Lock()
+	if c.files == nil {
+		c.files = map[string]*fileTemplate{}
+	}
+	c.files[fileName] = r
+	c.lock.Unlock()
+	return r.t, nil
+}
+
+func newEmailTemplateBase() *template.Template {
+	return template.New("").Funcs(template.FuncMap{
+		emailTemplateMessageFunc: func(key string, args ...interface{}) string { return key },
+	})
+}
+
+func (c *EmailTemplateRegistryImpl) Reload() error {
+	templates := map[string]*template.Template{}
+	modTime, err := c.lastModTime()
+	if err != nil {
+		return err
+	}
+
+	base := newEmailTemplateBase()
+	for _, shared := range []string{"layouts", "partials"} {
+		files, _ := filepath.Glob(filepath.Join(c.Dir, shared, "*.html"))
+		if len(files) == 0 {
+			continue
+		}
+		if base, err = base.ParseFiles(files...); err != nil {
+			return err
+		}
+	}
+
+	err = c.walkTemplates(func(path, name string) error {
+		t, err := base.Clone()
+		if err != nil {
+			return err
+		}
+		if t, err = t.ParseFiles(path); err != nil {
+			return err
+		}
+		templates[templateKey(name)] = t.Lookup(filepath.Base(path))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	c.base = base
+	c.templates = templates
+	// files are parsed again on use: they may include the changed layouts
+	c.files = nil
+	c.modTime = modTime
+	c.lastCheckAt = time.Now()
+	c.lock.Unlock()
+	return nil
+}
+
+func (c *EmailTemplateRegistryImpl) reloadIfChanged() {
+	if c.ReloadInterval < 0 {
+		return
+	}
+	c.lock.Lock()
+	if time.Since(c.lastCheckAt) < c.ReloadInterval {
+		c.lock.Unlock()
+		return
+	}
+	c.lastCheckAt = time.Now()
+	loadedModTime := c.modTime
+	c.lock.Unlock()
+
+	modTime, err := c.lastModTime()
+	if err != nil || !modTime.After(loadedModTime) {
+		return
+	}
+	if err = c.Reload(); err != nil {
+		// keep serving the previously loaded templates
+		if c.Logger != nil {
+			c.Logger.Println("email templates: " + err.Error())
+		}
+	}
+}
+
+// templateKey normalizes the locale suffix of a template name: "welcome.en_US" is stored as "welcome.en-us"
+func templateKey(name string) string {
+	if i := strings.LastIndex(name, "."); i >= 0 && !strings.Contains(name[i:], "/") {
+		return name[:i+1] + i18n.Normalize(name[i+1:])
+	}
+	return name
+}
+
+func (c *EmailTemplateRegistryImpl) walkTemplates(f func(path, name string) error) error {
+	if _, err := os.Stat(c.Dir); os.IsNotExist(err) {
+		return nil
+	}
+	return filepath.WalkDir(c.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(c.Dir, path)
+		if d.IsDir() {
+			if rel == "layouts" || rel == "partials" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".html" {
+			return nil
+		}
+		return f(path, filepath.ToSlash(strings.TrimSuffix(rel, ".html")))
+	})
+}
+
+func (c *EmailTemplateRegistryImpl) lastModTime() (time.Time, error) {
+	var r time.Time
+	if _, err := os.Stat(c.Dir); os.IsNotExist(err) {
+		return r, nil
+	}
+	err := filepath.WalkDir(c.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(r) {
+			r = info.ModTime()
+		}
+		return nil
+	})
+	return r, err
+}
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"github.com/itskovichanton/core/pkg/core/i18n"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEmailTemplateLocaleFallback(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"welcome.ru.html":    `ru {{t "greeting"}}`,
+		"welcome.en.html":    `en {{t "greeting"}}`,
+		"welcome.en_GB.html": `en-gb {{t "greeting"}}`,
+		"plain.html":         `plain {{t "greeting"}}`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	messages := i18n.NewCatalog("ru")
+	messages.Add("ru", map[string]string{"greeting": "Привет"})
+	messages.Add("en", map[string]string{"greeting": "Hello"})
+	r := &EmailTemplateRegistryImpl{Dir: dir, DefaultLocale: "ru", Messages: messages, ReloadInterval: -1}
+	if err := r.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct{ name, locale, expected string }{
+		{"welcome", "en", "en Hello"},
+		{"welcome", "en-US", "en Hello"},
+		{"welcome", "EN", "en Hello"},
+		{"welcome", "en_US", "en Hello"},
+		{"welcome", "en-GB", "en-gb Hello"},
+		{"welcome", "de-DE", "ru Привет"},
+		{"welcome", "", "ru Привет"},
+		{"plain", "en-US", "plain Hello"},
+	} {
+		rendered, err := r.Render(tc.name, tc.locale, nil)
+		if err != nil {
+			t.Fatalf("%v %q: %v", tc.name, tc.locale, err)
+		}
+		if rendered.HTML != tc.expected {
+			t.Fatalf("%v %q: got %q, expected %q", tc.name, tc.locale, rendered.HTML, tc.expected)
+		}
+	}
+	if !r.Has("welcome") || r.Has("missing") {
+		t.Fatal("Has doesn't match the loaded templates")
+	}
+}
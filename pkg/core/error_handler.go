@@ -1,26 +1,56 @@
 package core
 
 import (
+	"context"
+	"fmt"
+	"github.com/itskovichanton/core/pkg/core/frmclient"
+	"github.com/itskovichanton/core/pkg/core/validation"
+	"github.com/itskovichanton/goava/pkg/goava/errs"
 	"github.com/itskovichanton/goava/pkg/goava/utils"
 	"github.com/patrickmn/go-cache"
-	"github.com/spf13/cast"
+	"log"
 	"os"
+	"strings"
+	"sync"
+	"text/template"
 	"time"
 )
 
+const (
+	defaultAlertTemplate      = "developer_email"
+	defaultAlertSubjectFormat = "{{.App}}-[{{.Profile}}]"
+)
+
 type AlertParams struct {
 	Message, Subject string
 	Attachments      []*os.File
 	ByEmail, ByFR    bool
 	Level            int
 	Send             bool
+	Fingerprint      string
+	Meta             *RequestMeta
+
+	// tempFiles are the attachments made by AttachLogExcerpt and AttachHeapProfile, removed after sending
+	tempFiles []*os.File
+}
+
+type AlertTemplateData struct {
+	Msg           string
+	CorrelationID string
+	User          string
+	Action        string
 }
 
 type IErrorHandler interface {
 	HandleWithMessage(err error, message interface{}, byFR bool) *AlertParams
 	HandleWithCustomParams(err error, alertParamsPreprocessor func(alertParams *AlertParams)) *AlertParams
 	Handle(err error, byFR bool) *AlertParams
+	HandleCtx(ctx context.Context, err error, byFR bool) *AlertParams
+	HandleWithMessageCtx(ctx context.Context, err error, message interface{}, byFR bool) *AlertParams
+	HandleWithCustomParamsCtx(ctx context.Context, err error, alertParamsPreprocessor func(alertParams *AlertParams)) *AlertParams
 	SendAlert(a *AlertParams)
+	AttachLogExcerpt(a *AlertParams, logFileName string, maxBytes int64) error
+	AttachHeapProfile(a *AlertParams) error
 }
 
 type IParamsPostProcessor interface {
@@ -55,6 +85,9 @@ func (c *AlertParamsPostProcessorReducerImpl) getEntryParams(params *AlertParams
 	if c.GetEntry != nil {
 		return c.GetEntry(params)
 	}
+	if len(params.Fingerprint) > 0 {
+		return params.Fingerprint, 5 * time.Minute
+	}
 	return params.Subject + utils.MD5(params.Message), 5 * time.Minute
 }
 
@@ -62,17 +95,100 @@ type ErrorHandlerImpl struct {
 	IErrorHandler
 
 	EmailService        IEmailService
+	Templates           IEmailTemplateRegistry
 	Config              *Config
 	FRService           IFRService
 	ParamsPostProcessor IParamsPostProcessor
-	alertEmails         []string
+	AlertHistory        IAlertHistoryService
+	// Logger receives failures of the alerting itself, they are dropped when nil
+	Logger *log.Logger
+
+	emailEnabled      bool
+	alertEmails       []string
+	alertFrom         string
+	alertTemplate     string
+	alertTemplateFile string
+	alertSubject      string
+
+	emailAttachmentsMaxSize, frAttachmentsMaxSize uint64
 }
 
-func (c *ErrorHandlerImpl) Init() {
-	c.alertEmails = cast.ToStringSlice(c.Config.Get("alerts", "emails"))
-	if len(c.alertEmails) == 0 {
-		c.alertEmails = []string{"a.itskovich@molbulak.com"}
+func (c *ErrorHandlerImpl) Init() error {
+	if fr, ok := c.FRService.(*FRServiceImpl); ok && fr.OnPanic == nil {
+		fr.OnPanic = c.onSendPanic(true, false)
+	}
+	if emailService, ok := c.EmailService.(*EmailServiceImpl); ok && emailService.OnQueuePanic == nil {
+		emailService.OnQueuePanic = c.onSendPanic(false, true)
 	}
+	if err := c.initAlerts(); err != nil {
+		return err
+	}
+	return c.initAttachmentLimits()
+}
+
+func (c *ErrorHandlerImpl) initAlerts() error {
+	a := c.Config.Alerts
+	if a == nil {
+		return errs.NewBaseError("alerts are not configured: set alerts.from and alerts.emails or alerts.emailDisabled: true")
+	}
+
+	subjectFormat := a.Subject
+	if len(subjectFormat) == 0 {
+		subjectFormat = defaultAlertSubjectFormat
+	}
+	subjectTmpl, err := template.New("subject").Parse(subjectFormat)
+	if err != nil {
+		return errs.NewBaseErrorFromCauseMsg(err, "alerts.subject: "+err.Error())
+	}
+	subject := &strings.Builder{}
+	err = subjectTmpl.Execute(subject, map[string]string{
+		"App":     c.Config.App.GetFullName(),
+		"Name":    c.Config.App.Name,
+		"Version": c.Config.App.Version,
+		"Profile": c.Config.Profile,
+	})
+	if err != nil {
+		return errs.NewBaseErrorFromCauseMsg(err, "alerts.subject: "+err.Error())
+	}
+	c.alertSubject = subject.String()
+
+	if a.EmailDisabled {
+		return nil
+	}
+
+	if _, err = validation.CheckEmail("alerts.from", a.From); err != nil {
+		return err
+	}
+	if len(a.Emails) == 0 {
+		return errs.NewBaseError("alerts.emails: at least one recipient is required")
+	}
+	for i, e := range a.Emails {
+		if _, err = validation.CheckEmail(fmt.Sprintf("alerts.emails[%v]", i), e); err != nil {
+			return err
+		}
+	}
+
+	// The alert template is a name in the email template registry, or a file in resources as in older setups.
+	// The default one is optional: without it alerts go as plain text
+	name := a.Template
+	if len(name) == 0 {
+		name = defaultAlertTemplate
+	}
+	name = strings.TrimSuffix(name, ".html")
+	fileName := c.Config.GetResourceFilePath(name + ".html")
+	switch {
+	case c.Templates != nil && c.Templates.Has(name):
+		c.alertTemplate = name
+	case utils.FileExists(fileName):
+		c.alertTemplateFile = fileName
+	case len(a.Template) > 0:
+		return errs.NewBaseError("alerts.template: template not found: " + a.Template)
+	}
+
+	c.alertFrom = a.From
+	c.alertEmails = a.Emails
+	c.emailEnabled = true
+	return nil
 }
 
 func (c *ErrorHandlerImpl) HandleWithMessage(err error, message interface{}, byFR bool) *AlertParams {
@@ -83,15 +199,36 @@ func (c *ErrorHandlerImpl) HandleWithMessage(err error, message interface{}, byF
 }
 
 func (c *ErrorHandlerImpl) HandleWithCustomParams(err error, alertParamsPreprocessor func(alertParams *AlertParams)) *AlertParams {
+	return c.HandleWithCustomParamsCtx(context.Background(), err, alertParamsPreprocessor)
+}
+
+func (c *ErrorHandlerImpl) HandleCtx(ctx context.Context, err error, byFR bool) *AlertParams {
+	return c.HandleWithCustomParamsCtx(ctx, err, func(ap *AlertParams) {
+		ap.ByFR = byFR
+	})
+}
+
+func (c *ErrorHandlerImpl) HandleWithMessageCtx(ctx context.Context, err error, message interface{}, byFR bool) *AlertParams {
+	return c.HandleWithCustomParamsCtx(ctx, err, func(p *AlertParams) {
+		p.ByFR = byFR
+		p.Message += "\n" + utils.ToJson(message)
+	})
+}
+
+func (c *ErrorHandlerImpl) HandleWithCustomParamsCtx(ctx context.Context, err error, alertParamsPreprocessor func(alertParams *AlertParams)) *AlertParams {
 
 	alertParams := &AlertParams{
+		Meta:    GetRequestMeta(ctx),
 		Message: utils.GetErrorFullInfo(err),
-		Subject: c.Config.App.GetFullName() + "-[" + c.Config.Profile + "]",
+		Subject: c.alertSubject,
 		ByEmail: true,
 		Level:   1,
 		ByFR:    true,
 		Send:    true,
 	}
+	if info, ok := frmclient.GetErrorReasonInfo(err); ok && info.LogLevel > 0 {
+		alertParams.Level = info.LogLevel
+	}
 	alertParamsPreprocessor(alertParams)
 
 	c.SendAlert(alertParams)
@@ -105,58 +242,177 @@ func (c *ErrorHandlerImpl) Handle(err error, byFR bool) *AlertParams {
 	})
 }
 
-func (c *ErrorHandlerImpl) SendAlert(a *AlertParams) {
-
-	if !a.Send {
-		return
+func (c *ErrorHandlerImpl) onSendPanic(byEmail, byFR bool) func(err error) {
+	return func(err error) {
+		c.HandleWithCustomParams(err, func(p *AlertParams) {
+			p.ByEmail = byEmail
+			p.ByFR = byFR
+		})
 	}
+}
+
+func (c *ErrorHandlerImpl) SendAlert(a *AlertParams) {
 
 	if len(a.Subject) == 0 {
 		a.Subject = c.Config.App.Name
 	}
+	if len(a.Fingerprint) == 0 {
+		a.Fingerprint = alertFingerprint(a)
+	}
 
-	if c.ParamsPostProcessor != nil {
+	if a.Send && c.ParamsPostProcessor != nil {
 		c.ParamsPostProcessor.Process(a)
 	}
 
+	record := c.newAlertRecord(a)
+	temps := a.tempFiles
 	if !a.Send {
+		removeFiles(temps)
+		c.recordAlert(record)
 		return
 	}
 
+	byEmail := a.ByEmail && c.emailEnabled
+	if a.ByEmail && !byEmail {
+		record.Delivery[AlertChannelEmail] = AlertDeliverySkipped
+	}
+	if !byEmail && !a.ByFR {
+		removeFiles(temps)
+		c.recordAlert(record)
+		return
+	}
+	// the caller keeps the params, the delivery works on its own copy
+	alert := *a
+	alert.Attachments = append([]*os.File{}, a.Attachments...)
+	Go(func() { c.deliverAlert(record, &alert, byEmail, temps) }, c.onSendPanic(false, a.ByFR))
+}
+
+// deliverAlert fits the attachments, sends the alert by email and FR at the same time,
+// records both outcomes once they are known and removes the temp attachments
+func (c *ErrorHandlerImpl) deliverAlert(record *AlertRecord, a *AlertParams, byEmail bool, temps []*os.File) {
+	defer func() { removeFiles(temps) }()
+
 	var pr *Params
-	if a.ByEmail {
+	if byEmail {
+		attachments, fitted := c.fitAttachments(a.Attachments, c.emailAttachmentsMaxSize)
+		temps = append(temps, fitted...)
 		pr = &Params{
-			From:    "finstart.mailer@molbulak.com",
-			To:      c.alertEmails,
-			Subject: a.Subject,
-			Body:    a.Message,
-			Template: &Template{
-				TemplateFileName: c.Config.GetResourceFilePath("developer_email.html"),
-				Data: struct {
-					Msg string
-				}{
-					Msg: a.Message,
-				},
-			},
-			AttachmentFileNames: []string{},
+			From:                c.alertFrom,
+			To:                  c.alertEmails,
+			Subject:             a.Subject,
+			Body:                a.Meta.String() + a.Message,
+			AttachmentFileNames: fileNames(attachments),
+		}
+		if len(c.alertTemplate) > 0 || len(c.alertTemplateFile) > 0 {
+			pr.Template = &Template{
+				Name:             c.alertTemplate,
+				TemplateFileName: c.alertTemplateFile,
+				Data:             newAlertTemplateData(a),
+			}
 		}
 	}
 
+	var post *Post
 	if a.ByFR {
-		p := Post{
-			project: a.Subject,
-			msg:     utils.ChopOffString(a.Message, 4000),
-			level:   a.Level,
+		attachments, fitted := c.fitAttachments(a.Attachments, c.frAttachmentsMaxSize)
+		temps = append(temps, fitted...)
+		p, err := NewPost(a.Subject, utils.ChopOffString(a.Meta.String()+a.Message, 4000)).
+			Level(a.Level).
+			AttachFiles(attachments...).
+			Build()
+		if err != nil {
+			record.Delivery[AlertChannelFR] = err.Error()
+		} else {
+			post = p
 		}
-		if len(a.Attachments) > 0 {
-			p.attachment = a.Attachments[0]
-		}
-		c.FRService.PostMsg(&p)
+	}
 
+	var frErr error
+	var wg sync.WaitGroup
+	if post != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer RecoverTo(func(err error) { frErr = err })
+			frErr = c.FRService.PostMsgSync(context.Background(), post)
+		}()
 	}
+	var emailErr error
+	if pr != nil {
+		emailErr = c.EmailService.Send(pr)
+	}
+	wg.Wait()
 
+	if post != nil {
+		record.Delivery[AlertChannelFR] = deliveryOutcome(frErr, AlertDeliveryPosted)
+	}
 	if pr != nil {
-		go c.EmailService.Send(pr)
+		record.Delivery[AlertChannelEmail] = deliveryOutcome(emailErr, AlertDeliverySent)
 	}
+	c.recordAlert(record)
+}
 
+func deliveryOutcome(err error, success string) string {
+	if err != nil {
+		return err.Error()
+	}
+	return success
+}
+
+// alertFingerprint leaves the correlation ID out: it is unique per request and would defeat deduplication.
+func alertFingerprint(a *AlertParams) string {
+	s := a.Subject + "\n" + a.Message
+	if a.Meta != nil {
+		s += "\n" + a.Meta.User + "\n" + a.Meta.Action
+	}
+	return utils.MD5(s)
+}
+
+func newAlertTemplateData(a *AlertParams) *AlertTemplateData {
+	r := &AlertTemplateData{Msg: a.Message}
+	if a.Meta != nil {
+		r.CorrelationID = a.Meta.CorrelationID
+		r.User = a.Meta.User
+		r.Action = a.Meta.Action
+	}
+	return r
+}
+
+func (c *ErrorHandlerImpl) newAlertRecord(a *AlertParams) *AlertRecord {
+	r := &AlertRecord{
+		Fingerprint: a.Fingerprint,
+		Subject:     a.Subject,
+		Message:     utils.ChopOffString(a.Message, 1000),
+		Level:       a.Level,
+		Meta:        a.Meta,
+		Suppressed:  !a.Send,
+		Delivery:    map[string]string{},
+		CreatedAt:   time.Now(),
+	}
+	if a.ByEmail {
+		r.Channels = append(r.Channels, AlertChannelEmail)
+	}
+	if a.ByFR {
+		r.Channels = append(r.Channels, AlertChannelFR)
+	}
+	return r
+}
+
+func (c *ErrorHandlerImpl) recordAlert(r *AlertRecord) {
+	if c.AlertHistory == nil {
+		return
+	}
+	if !r.Suppressed {
+		now := time.Now()
+		r.DeliveredAt = &now
+	}
+	if err := c.AlertHistory.Record(r); err != nil {
+		c.logError("alert history", err)
+	}
+}
+
+func (c *ErrorHandlerImpl) logError(msg string, err error) {
+	if c.Logger != nil {
+		c.Logger.Println(msg + ": " + err.Error())
+	}
 }
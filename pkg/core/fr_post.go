@@ -0,0 +1,176 @@
+package core
+
+import (
+	"bytes"
+	"github.com/itskovichanton/goava/pkg/goava/errs"
+	"github.com/itskovichanton/goava/pkg/goava/utils"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Post is a message for IFRService.PostMsg, build it with NewPost
+type Post struct {
+	project, msg string
+	level        int
+	tags         []string
+	ids          []int
+	attachments  []*PostAttachment
+}
+
+// PostAttachment is opened anew for every delivery attempt and endpoint, so a post can be retried and fanned out
+type PostAttachment struct {
+	Name string
+	open func() (io.ReadCloser, error)
+}
+
+type PostBuilder struct {
+	post *Post
+	err  error
+}
+
+func NewPost(project, msg string) *PostBuilder {
+	return &PostBuilder{post: &Post{project: project, msg: msg}}
+}
+
+func (b *PostBuilder) Level(level int) *PostBuilder {
+	b.post.level = level
+	return b
+}
+
+func (b *PostBuilder) Tags(tags ...string) *PostBuilder {
+	b.post.tags = append(b.post.tags, tags...)
+	return b
+}
+
+// RecipientIds are added to the developer ids configured for the endpoint
+func (b *PostBuilder) RecipientIds(ids ...int) *PostBuilder {
+	b.post.ids = append(b.post.ids, ids...)
+	return b
+}
+
+// AttachFile attaches the file by name, it is read when the post is delivered
+func (b *PostBuilder) AttachFile(fileName string) *PostBuilder {
+	if b.err == nil && !utils.FileExists(fileName) {
+		b.err = errs.NewBaseError("attachment not found: " + fileName)
+	}
+	b.post.attachments = append(b.post.attachments, &PostAttachment{
+		Name: filepath.Base(fileName),
+		open: func() (io.ReadCloser, error) { return os.Open(fileName) },
+	})
+	return b
+}
+
+func (b *PostBuilder) AttachFiles(files ...*os.File) *PostBuilder {
+	for _, f := range files {
+		b.AttachFile(f.Name())
+	}
+	return b
+}
+
+func (b *PostBuilder) AttachBytes(name string, data []byte) *PostBuilder {
+	b.post.attachments = append(b.post.attachments, &PostAttachment{
+		Name: name,
+		open: func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(data)), nil },
+	})
+	return b
+}
+
+// AttachReader rewinds an io.ReadSeeker before every delivery attempt. Any other reader is read
+// into memory once, on the first attempt, so pass a file or an io.ReadSeeker for large content.
+func (b *PostBuilder) AttachReader(name string, r io.Reader) *PostBuilder {
+	var open func() (io.ReadCloser, error)
+	if rs, ok := r.(io.ReadSeeker); ok {
+		// fan-out reads the same seeker from several goroutines, so it is held until the part is written
+		lock := &sync.Mutex{}
+		open = func() (io.ReadCloser, error) {
+			lock.Lock()
+			if _, err := rs.Seek(0, io.SeekStart); err != nil {
+				lock.Unlock()
+				return nil, err
+			}
+			return &lockedReader{Reader: rs, lock: lock}, nil
+		}
+	} else {
+		var once sync.Once
+		var data []byte
+		var err error
+		open = func() (io.ReadCloser, error) {
+			once.Do(func() { data, err = io.ReadAll(r) })
+			return io.NopCloser(bytes.NewReader(data)), err
+		}
+	}
+	b.post.attachments = append(b.post.attachments, &PostAttachment{Name: name, open: open})
+	return b
+}
+
+func (b *PostBuilder) Build() (*Post, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.post, nil
+}
+
+// writeTo streams the post as multipart/form-data, ids are the recipients configured for the endpoint
+func (a *Post) writeTo(writer *multipart.Writer, ids []int) error {
+	writer.WriteField("msg", a.msg)
+	writer.WriteField("project", a.project)
+	writer.WriteField("level", strconv.Itoa(a.level))
+	if len(a.tags) > 0 {
+		writer.WriteField("tags", strings.Join(a.tags, ","))
+	}
+	if ids = mergeIds(ids, a.ids); len(ids) > 0 {
+		writer.WriteField("ids", strings.Join(utils.ToStringSliceInts(ids), ","))
+	}
+	for _, attachment := range a.attachments {
+		if err := attachment.writeTo(writer); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+func (a *PostAttachment) writeTo(writer *multipart.Writer) error {
+	src, err := a.open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	part, err := writer.CreateFormFile("attachment", a.Name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, src)
+	return err
+}
+
+type lockedReader struct {
+	io.Reader
+	lock *sync.Mutex
+}
+
+func (r *lockedReader) Close() error {
+	r.lock.Unlock()
+	return nil
+}
+
+func mergeIds(a, b []int) []int {
+	r := append([]int{}, a...)
+	for _, id := range b {
+		found := false
+		for _, existing := range r {
+			if existing == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			r = append(r, id)
+		}
+	}
+	return r
+}
@@ -1,23 +1,42 @@
 package core
 
 import (
-	"bytes"
-	"fmt"
-	"github.com/itskovichanton/goava/pkg/goava/httputils"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/itskovichanton/core/pkg/core/frmclient"
+	"io"
+	"log"
 	"mime/multipart"
+	"net"
 	"net/http"
-	"os"
-	"strconv"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-type Post struct {
-	project, msg string
-	level        int
-	attachment   *os.File
+const (
+	defaultFRTimeout     = 30 * time.Second
+	defaultFRMaxAttempts = 3
+	defaultFRMinBackoff  = time.Second
+	defaultFRMaxBackoff  = 10 * time.Second
+)
+
+// FRStats counts deliveries per endpoint, a post that failed over to a secondary counts as failed and sent
+type FRStats struct {
+	Sent    int64 `json:"sent"`
+	Failed  int64 `json:"failed"`
+	Retried int64 `json:"retried"`
 }
 
 type IFRService interface {
+	// PostMsg delivers the post in the background, failures are only logged
 	PostMsg(post *Post)
+	// PostMsgSync delivers the post and returns the last error once retries are exhausted
+	PostMsgSync(ctx context.Context, post *Post) error
+	GetStats() *FRStats
 }
 
 type FRServiceImpl struct {
@@ -25,62 +44,234 @@ type FRServiceImpl struct {
 
 	Config     *Config
 	HttpClient *http.Client
+	OnPanic    func(err error)
+	// Logger receives a JSON line per delivery attempt, nothing is logged when nil
+	Logger *log.Logger
+
+	sent, failed, retried int64
+}
+
+// FRDeliveryError lists the endpoints that did not accept a post by their names (urls when unnamed)
+type FRDeliveryError struct {
+	Errors map[string]error
+}
+
+func (e *FRDeliveryError) Error() string {
+	var r []string
+	for target, err := range e.Errors {
+		r = append(r, target+": "+err.Error())
+	}
+	sort.Strings(r)
+	return strings.Join(r, "; ")
 }
 
 func (c *FRServiceImpl) PostMsg(a *Post) {
-	if c.Config.FR != nil {
-		go func() { c.postMsg(a, c.Config.FR) }()
+	if len(c.targets(a)) > 0 {
+		Go(func() { c.PostMsgSync(context.Background(), a) }, c.OnPanic)
+	}
+}
+
+// PostMsgSync fans the post out to every endpoint whose MinLevel it reaches and waits for all of them
+func (c *FRServiceImpl) PostMsgSync(ctx context.Context, a *Post) error {
+	targets := c.targets(a)
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, fr := range targets {
+		i, fr := i, fr
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer RecoverTo(func(err error) { errs[i] = err })
+			errs[i] = c.postMsgWithFailover(ctx, a, fr)
+		}()
+	}
+	wg.Wait()
+
+	r := &FRDeliveryError{Errors: map[string]error{}}
+	for i, err := range errs {
+		if err != nil {
+			r.Errors[frName(targets[i])] = err
+		}
+	}
+	if len(r.Errors) == 0 {
+		return nil
+	}
+	return r
+}
+
+func (c *FRServiceImpl) targets(a *Post) []*FR {
+	var r []*FR
+	for _, fr := range c.Config.GetFRTargets() {
+		if a.level >= fr.MinLevel {
+			r = append(r, fr)
+		}
+	}
+	return r
+}
+
+// postMsgWithFailover moves on to the secondary endpoint only while the current one is unavailable:
+// a rejection by a reachable server would be repeated by its secondary as well.
+func (c *FRServiceImpl) postMsgWithFailover(ctx context.Context, a *Post, fr *FR) error {
+	for {
+		err := c.postMsg(ctx, a, fr)
+		if err != nil {
+			atomic.AddInt64(&c.failed, 1)
+		} else {
+			atomic.AddInt64(&c.sent, 1)
+		}
+		if err == nil || fr.Secondary == nil || !isRetryableFRError(err) || ctx.Err() != nil {
+			return err
+		}
+		fr = fr.Secondary
+	}
+}
+
+func frName(fr *FR) string {
+	if len(fr.Name) > 0 {
+		return fr.Name
+	}
+	return fr.Url
+}
+
+func (c *FRServiceImpl) GetStats() *FRStats {
+	return &FRStats{
+		Sent:    atomic.LoadInt64(&c.sent),
+		Failed:  atomic.LoadInt64(&c.failed),
+		Retried: atomic.LoadInt64(&c.retried),
+	}
+}
+
+func (c *FRServiceImpl) postMsg(ctx context.Context, a *Post, fr *FR) error {
+	timeout := fr.Timeout
+	if timeout <= 0 {
+		timeout = defaultFRTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	maxAttempts := fr.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultFRMaxAttempts
+	}
+	for attempt := 1; ; attempt++ {
+		err := c.send(ctx, a, fr)
+		c.log(fr, a, attempt, err)
+		if err == nil || attempt >= maxAttempts || !isRetryableFRError(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(frBackoff(fr, attempt)):
+		}
+		atomic.AddInt64(&c.retried, 1)
 	}
-	//if a.level > 2 && c.Config.FR2 != nil {
-	//	go func() { c.postMsg(a, c.Config.FR2) }()
-	//}
 }
 
-func (c *FRServiceImpl) postMsg(a *Post, fr *FR) (string, error) {
-	req, err := c.getPostHttpRequest(a, fr)
+// send streams the multipart body through a pipe, attachments are never loaded into memory as a whole
+func (c *FRServiceImpl) send(ctx context.Context, a *Post, fr *FR) error {
+	body, writer := io.Pipe()
+	multipartWriter := multipart.NewWriter(writer)
+	go func() {
+		if err := a.writeTo(multipartWriter, frDeveloperIds(fr)); err != nil {
+			writer.CloseWithError(&frBodyError{err})
+			return
+		}
+		writer.Close()
+	}()
+	defer body.Close()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fr.Url+"/postMsg", body)
 	if err != nil {
-		return "", err
+		return err
+	}
+	req.Header.Set("Content-Type", multipartWriter.FormDataContentType())
+	if len(fr.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+fr.Token)
 	}
 	resp, err := c.HttpClient.Do(req)
 	if err != nil {
-		return "", err
+		return err
 	}
+	_, err = frmclient.ReadResult(resp, nil)
+	return err
+}
 
-	body := &bytes.Buffer{}
-	_, err = body.ReadFrom(resp.Body)
-	if err != nil {
-		return "", err
-	}
-	//resp.Body.Close()
-	//fmt.Println(resp.StatusCode)
-	//fmt.Println(resp.Header)
-	b := fmt.Sprint(body)
-	return b, nil
-}
-
-func (c *FRServiceImpl) getPostHttpRequest(a *Post, fr *FR) (*http.Request, error) {
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	writer.WriteField("msg", a.msg)
-	writer.WriteField("project", a.project)
-	writer.WriteField("level", strconv.Itoa(a.level))
-	//writer.WriteField("ids", strings.Join(utils.ToStringSliceInts([]int{fr.DeveloperId}), ","))
-	if a.attachment != nil {
-		err := httputils.AddFile("attachment", a.attachment.Name(), writer)
-		if err != nil {
-			return nil, err
+// frBodyError is a failure to build the request body, such as an unreadable attachment: sending again won't help
+type frBodyError struct {
+	err error
+}
+
+func (e *frBodyError) Error() string {
+	return "building the FR request: " + e.err.Error()
+}
+
+func (e *frBodyError) Unwrap() error {
+	return e.err
+}
+
+// isRetryableFRError treats transport failures, 5xx and 429 answers as temporary.
+// Other answers of the server and local failures, like building the body, are final.
+func isRetryableFRError(err error) bool {
+	var bodyErr *frBodyError
+	if errors.As(err, &bodyErr) {
+		return false
+	}
+	var frmErr *frmclient.FrmClientError
+	if errors.As(err, &frmErr) {
+		if frmErr.StatusCode == 0 {
+			return frmclient.IsRetryable(err) || errors.Is(err, frmclient.ErrTimeout)
 		}
+		return frmErr.StatusCode >= 500 || frmErr.StatusCode == http.StatusTooManyRequests
 	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
 
-	err := writer.Close()
-	if err != nil {
-		return nil, err
+func frBackoff(fr *FR, attempt int) time.Duration {
+	r, max := fr.MinBackoff, fr.MaxBackoff
+	if r <= 0 {
+		r = defaultFRMinBackoff
+	}
+	if max <= 0 {
+		max = defaultFRMaxBackoff
+	}
+	for i := 1; i < attempt && r < max; i++ {
+		r *= 2
+	}
+	if r > max {
+		r = max
 	}
+	return r
+}
 
-	req, err := http.NewRequest("POST", fr.Url+"/postMsg", body)
+func (c *FRServiceImpl) log(fr *FR, a *Post, attempt int, err error) {
+	if c.Logger == nil {
+		return
+	}
+	ld := map[string]interface{}{
+		"a":       "fr-post",
+		"fr":      frName(fr),
+		"project": a.project,
+		"level":   a.level,
+		"attempt": attempt,
+		"tm":      time.Now().UnixMilli(),
+	}
 	if err != nil {
-		return nil, err
+		ld["err"] = err.Error()
+	}
+	line, _ := json.Marshal(ld)
+	c.Logger.Println(string(line))
+}
+
+func frDeveloperIds(fr *FR) []int {
+	if fr.DeveloperId == 0 {
+		return fr.DeveloperIds
+	}
+	for _, id := range fr.DeveloperIds {
+		if id == fr.DeveloperId {
+			return fr.DeveloperIds
+		}
 	}
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	return req, err
+	return append([]int{fr.DeveloperId}, fr.DeveloperIds...)
 }
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newFRServer(t *testing.T, status int) (*httptest.Server, *int32) {
+	var requests int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(status)
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(s.Close)
+	return s, &requests
+}
+
+func TestFRRetries(t *testing.T) {
+	for _, tc := range []struct {
+		name                         string
+		status                       int
+		primaryCalls, secondaryCalls int32
+	}{
+		{"ok", http.StatusOK, 1, 0},
+		{"unavailable", http.StatusServiceUnavailable, 3, 3},
+		{"rate limited", http.StatusTooManyRequests, 3, 3},
+		{"rejected", http.StatusBadRequest, 1, 0},
+		{"conflict", http.StatusConflict, 1, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			primary, primaryCalls := newFRServer(t, tc.status)
+			secondary, secondaryCalls := newFRServer(t, tc.status)
+			fr := &FR{Url: primary.URL, MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond,
+				Secondary: &FR{Url: secondary.URL, MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}}
+			post, err := NewPost("test", "message").AttachBytes("a.txt", []byte("data")).Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = (&FRServiceImpl{HttpClient: http.DefaultClient}).postMsgWithFailover(context.Background(), post, fr)
+			if (err == nil) != (tc.status == http.StatusOK) {
+				t.Fatalf("unexpected result %v", err)
+			}
+			if n := atomic.LoadInt32(primaryCalls); n != tc.primaryCalls {
+				t.Fatalf("%v posts to the primary endpoint expected, got %v", tc.primaryCalls, n)
+			}
+			if n := atomic.LoadInt32(secondaryCalls); n != tc.secondaryCalls {
+				t.Fatalf("%v posts to the secondary endpoint expected, got %v", tc.secondaryCalls, n)
+			}
+		})
+	}
+}
+
+func TestFRBrokenAttachmentIsNotRetried(t *testing.T) {
+	primary, primaryCalls := newFRServer(t, http.StatusOK)
+	secondary, secondaryCalls := newFRServer(t, http.StatusOK)
+	fr := &FR{Url: primary.URL, MaxAttempts: 3, MinBackoff: time.Millisecond, Secondary: &FR{Url: secondary.URL}}
+
+	fileName := filepath.Join(t.TempDir(), "log.txt")
+	if err := os.WriteFile(fileName, []byte("log"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	post, err := NewPost("test", "message").AttachFile(fileName).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(fileName)
+
+	err = (&FRServiceImpl{HttpClient: http.DefaultClient}).postMsgWithFailover(context.Background(), post, fr)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("missing attachment error expected, got %v", err)
+	}
+	if n := atomic.LoadInt32(primaryCalls) + atomic.LoadInt32(secondaryCalls); n > 1 {
+		t.Fatalf("a broken attachment must not be posted again, %v posts done", n)
+	}
+}
@@ -0,0 +1,214 @@
+package frmclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/itskovichanton/goava/pkg/goava/errs"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers of HMAC signed requests
+const (
+	HeaderKeyId     = "X-Frm-Key"
+	HeaderTimestamp = "X-Frm-Timestamp"
+	HeaderNonce     = "X-Frm-Nonce"
+	HeaderSignature = "X-Frm-Signature"
+)
+
+// Authenticator adds credentials to an outgoing request, it is called for every attempt
+type Authenticator interface {
+	Authenticate(r *http.Request) error
+}
+
+// Refresher is implemented by authenticators whose credentials expire.
+// The client refreshes them and repeats the request once when the server answers AUTHORIZATION_REQUIRED.
+type Refresher interface {
+	// Refresh renews the credentials rejected for r, concurrent calls for the same credentials renew them once
+	Refresh(ctx context.Context, r *http.Request) error
+}
+
+type BearerAuth struct {
+	Token string
+}
+
+func (a *BearerAuth) Authenticate(r *http.Request) error {
+	r.Header.Set("Authorization", "Bearer "+a.Token)
+	return nil
+}
+
+// TokenAuth is a bearer token obtained from Fetch, fetched lazily and again after AUTHORIZATION_REQUIRED.
+// Fetch runs once at a time and only while the token is still the rejected one,
+// so requests failing together with an expired token don't fetch it again each.
+type TokenAuth struct {
+	Fetch func(ctx context.Context) (string, error)
+
+	fetchLock sync.Mutex
+	lock      sync.Mutex
+	token     string
+}
+
+func (a *TokenAuth) Authenticate(r *http.Request) error {
+	token := a.getToken()
+	if len(token) == 0 {
+		var err error
+		if token, err = a.refresh(r.Context(), ""); err != nil {
+			return err
+		}
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func (a *TokenAuth) Refresh(ctx context.Context, r *http.Request) error {
+	rejected, _ := bearerToken(r)
+	_, err := a.refresh(ctx, rejected)
+	return err
+}
+
+// refresh fetches a new token unless the token has changed from stale while waiting for another fetch
+func (a *TokenAuth) refresh(ctx context.Context, stale string) (string, error) {
+	a.fetchLock.Lock()
+	defer a.fetchLock.Unlock()
+	if token := a.getToken(); token != stale {
+		return token, nil
+	}
+	token, err := a.Fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	a.lock.Lock()
+	a.token = token
+	a.lock.Unlock()
+	return token, nil
+}
+
+func (a *TokenAuth) getToken() string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.token
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || len(token) == 0 {
+		return "", false
+	}
+	return token, true
+}
+
+// HMACAuth signs the method, the url, a timestamp, a nonce and the body hash with a shared secret.
+// The body is hashed before it is sent, so streamed bodies are rejected: pass a []byte based reader
+// (*bytes.Reader, *bytes.Buffer, *strings.Reader) or a request encoded by CallWith instead.
+type HMACAuth struct {
+	KeyId  string
+	Secret string
+}
+
+func (a *HMACAuth) Authenticate(r *http.Request) error {
+	if r.Body != nil && r.Body != http.NoBody && r.GetBody == nil {
+		return errs.NewBaseError("HMACAuth can't sign a streamed body of " + r.Method + " " + r.URL.String())
+	}
+	body, err := ReadBody(r)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, 16)
+	if _, err = rand.Read(nonce); err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonceStr := hex.EncodeToString(nonce)
+
+	r.Header.Set(HeaderKeyId, a.KeyId)
+	r.Header.Set(HeaderTimestamp, timestamp)
+	r.Header.Set(HeaderNonce, nonceStr)
+	r.Header.Set(HeaderSignature, HMACSignature(a.Secret, r.Method, r.URL.RequestURI(), timestamp, nonceStr, body))
+	return nil
+}
+
+// HMACSignature is the signature of HMACAuth, servers recompute it to verify requests
+func HMACSignature(secret, method, requestURI, timestamp, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + requestURI + "\n" + timestamp + "\n" + nonce + "\n" + hex.EncodeToString(bodyHash[:])))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ReadBody returns the body of r and leaves r readable again
+func ReadBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	if r.GetBody != nil {
+		body, err := r.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return io.ReadAll(body)
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
+
+// authenticator returns Client.Auth, or the scheme configured in Config
+func (c *Client) authenticator() Authenticator {
+	if c.Auth != nil {
+		return c.Auth
+	}
+	if c.Config == nil {
+		return nil
+	}
+	if len(c.Config.HMACSecret) > 0 {
+		return &HMACAuth{KeyId: c.Config.HMACKeyId, Secret: c.Config.HMACSecret}
+	}
+	if len(c.Config.Token) > 0 {
+		return &BearerAuth{Token: c.Config.Token}
+	}
+	return nil
+}
+
+// send builds the request and runs attempt. When the server answers AUTHORIZATION_REQUIRED
+// and the authenticator is a Refresher the credentials are refreshed and the request is sent once more.
+func (c *Client) send(ctx context.Context, method, rawUrl string, req interface{}, attempt func(r *http.Request) error) error {
+	r, err := c.NewRequest(ctx, method, rawUrl, req)
+	if err != nil {
+		return err
+	}
+	err = attempt(r)
+	refresher, ok := c.authenticator().(Refresher)
+	if !ok || !errors.Is(err, ErrAuthorizationRequired) || !replayable(req) {
+		return err
+	}
+	if refreshErr := refresher.Refresh(ctx, r); refreshErr != nil {
+		return err
+	}
+	if s, ok := req.(io.Seeker); ok {
+		if _, err = s.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	if r, err = c.NewRequest(ctx, method, rawUrl, req); err != nil {
+		return err
+	}
+	return attempt(r)
+}
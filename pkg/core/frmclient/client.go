@@ -0,0 +1,223 @@
+package frmclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/itskovichanton/goava/pkg/goava/errs"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+type Config struct {
+	// BaseUrl is prepended to relative urls passed to Call
+	BaseUrl string
+	// Headers are set on every request
+	Headers map[string]string
+	// Policy applies to urls not matched by Policies, calls are sent once when it is nil
+	Policy *Policy
+	// Policies by url prefix (as passed to Call), the longest matching prefix wins
+	Policies map[string]*Policy
+	// Token is sent as a bearer token when Client.Auth is nil
+	Token string
+	// HMACKeyId and HMACSecret sign requests with HMACAuth when Client.Auth is nil, they win over Token
+	HMACKeyId  string
+	HMACSecret string
+}
+
+type Client struct {
+	Config     *Config
+	HttpClient *http.Client
+	// Auth adds credentials to every request, see Config.Token and Config.HMACSecret for the configured schemes
+	Auth Authenticator
+	// OnBreakerStateChange is called when a circuit breaker opens, half-opens or closes
+	OnBreakerStateChange func(change *BreakerStateChange)
+
+	breakers sync.Map
+}
+
+var DefaultClient = &Client{Config: &Config{}, HttpClient: http.DefaultClient}
+
+// Call invokes a Frm-style API with DefaultClient, see CallWith
+func Call[T any](ctx context.Context, method, url string, req interface{}) (T, error) {
+	return CallWith[T](ctx, DefaultClient, method, url, req)
+}
+
+// CallWith sends req and decodes the result of the {result, error} envelope into T.
+//
+// For GET and DELETE req goes to the query: url.Values, map[string]string or a struct encoded via its json tags.
+// For other methods url.Values is sent as a form, io.Reader as is and anything else as JSON.
+// Failures are *FrmClientError, compare them with the Err* values of reasons.go using errors.Is.
+func CallWith[T any](ctx context.Context, c *Client, method, url string, req interface{}) (T, error) {
+	var r T
+	err := c.execute(ctx, method, url, req, func(httpReq *http.Request) error {
+		resp, err := c.do(httpReq)
+		if err != nil {
+			return err
+		}
+		r = *new(T)
+		_, err = ReadResult(resp, &r)
+		return err
+	})
+	return r, err
+}
+
+// Download writes the body of a successful response to w as is, error responses are decoded like in CallWith
+func (c *Client) Download(ctx context.Context, method, url string, req interface{}, w io.Writer) (int64, error) {
+	var n int64
+	err := c.execute(ctx, method, url, req, func(httpReq *http.Request) error {
+		resp, err := c.do(httpReq)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			_, err = ReadResult(resp, nil)
+			return err
+		}
+		defer resp.Body.Close()
+		n, err = io.Copy(w, resp.Body)
+		return err
+	})
+	return n, err
+}
+
+func (c *Client) NewRequest(ctx context.Context, method, rawUrl string, req interface{}) (*http.Request, error) {
+	u, err := url.Parse(c.resolve(rawUrl))
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.Reader
+	contentType := ""
+	if method == http.MethodGet || method == http.MethodDelete {
+		if req != nil {
+			values, err := toValues(req)
+			if err != nil {
+				return nil, err
+			}
+			q := u.Query()
+			for k, v := range values {
+				q[k] = append(q[k], v...)
+			}
+			u.RawQuery = q.Encode()
+		}
+	} else {
+		switch v := req.(type) {
+		case nil:
+		case url.Values:
+			body, contentType = strings.NewReader(v.Encode()), "application/x-www-form-urlencoded"
+		case io.Reader:
+			body = v
+		default:
+			data, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			body, contentType = bytes.NewReader(data), "application/json"
+		}
+	}
+
+	r, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if len(contentType) > 0 {
+		r.Header.Set("Content-Type", contentType)
+	}
+	if c.Config != nil {
+		for k, v := range c.Config.Headers {
+			r.Header.Set(k, v)
+		}
+	}
+	if auth := c.authenticator(); auth != nil {
+		if err = auth.Authenticate(r); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (c *Client) resolve(rawUrl string) string {
+	if c.Config == nil || len(c.Config.BaseUrl) == 0 || strings.Contains(rawUrl, "://") {
+		return rawUrl
+	}
+	return strings.TrimSuffix(c.Config.BaseUrl, "/") + "/" + strings.TrimPrefix(rawUrl, "/")
+}
+
+func (c *Client) do(r *http.Request) (*http.Response, error) {
+	httpClient := c.HttpClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(r)
+	if err != nil {
+		reason := transportErrorReason(r.Context(), err)
+		return nil, &FrmClientError{
+			BaseError: *errs.NewBaseErrorFromCauseMsgReason(err, err.Error(), reason),
+			Err:       &Err{Reason: reason, Message: err.Error()},
+		}
+	}
+	return resp, nil
+}
+
+// transportErrorReason classifies an error of http.Client.Do: the end of the request context is reported as is,
+// only a failed dial means the server is unavailable, failures after the connection was made are network errors
+func transportErrorReason(ctx context.Context, err error) string {
+	switch {
+	case errors.Is(err, context.Canceled) && ctx.Err() != nil:
+		return ReasonCanceled
+	case errors.Is(err, context.DeadlineExceeded) && ctx.Err() != nil:
+		return ReasonTimeout
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return ReasonServerUnavailable
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return ReasonServerUnavailable
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ReasonTimeout
+	}
+	return ReasonNetwork
+}
+
+func toValues(req interface{}) (url.Values, error) {
+	switch v := req.(type) {
+	case url.Values:
+		return v, nil
+	case map[string]string:
+		r := url.Values{}
+		for k, s := range v {
+			r.Set(k, s)
+		}
+		return r, nil
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	r := url.Values{}
+	for k, f := range fields {
+		switch fv := f.(type) {
+		case nil:
+		case string:
+			r.Set(k, fv)
+		default:
+			s, _ := json.Marshal(fv)
+			r.Set(k, string(s))
+		}
+	}
+	return r, nil
+}
@@ -7,8 +7,13 @@ import (
 	"github.com/itskovichanton/goava/pkg/goava/utils"
 	"io"
 	"net/http"
+	"strconv"
+	"time"
 )
 
+// ExecuteWidthFrmAPI decodes resp into resultGetter, a *bytes.Buffer receives the raw body of a 200 response.
+//
+// Deprecated: use Call for JSON results and Client.Download for binary content.
 func ExecuteWidthFrmAPI(resp *http.Response, resultGetter interface{}) (interface{}, error) {
 
 	defer resp.Body.Close()
@@ -18,7 +23,6 @@ func ExecuteWidthFrmAPI(resp *http.Response, resultGetter interface{}) (interfac
 	}
 
 	if resultGetter != nil {
-		println(utils.GetType(resultGetter))
 		switch e := resultGetter.(type) {
 		case *bytes.Buffer:
 			if resp.StatusCode == 200 {
@@ -57,9 +61,104 @@ func ExecuteWidthFrmAPI(resp *http.Response, resultGetter interface{}) (interfac
 
 }
 
+// ReadResult closes resp.Body and decodes the Result envelope, unmarshalling its result into res if it is not nil.
+// An error in the envelope or a non-2xx status without one is returned as *FrmClientError.
+func ReadResult(resp *http.Response, res interface{}) (*Result, error) {
+	defer resp.Body.Close()
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Result{Res: res}
+	parseErr := json.Unmarshal(respBytes, r)
+	if parseErr == nil && r.Err != nil {
+		return r, &FrmClientError{
+			BaseError:  *errs.NewBaseErrorWithReason(r.Err.Message, r.Err.Reason),
+			Err:        r.Err,
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		e := &Err{
+			Reason:  ReasonForStatus(resp.StatusCode),
+			Message: resp.Status,
+			Details: utils.ChopOffString(string(respBytes), 1000),
+		}
+		return r, &FrmClientError{
+			BaseError:  *errs.NewBaseErrorWithReason(e.Message, e.Reason),
+			Err:        e,
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	return r, nil
+}
+
+// ReasonForStatus maps an HTTP status of a response without a Result envelope to a reason.
+// Only 5xx and 429 get retryable reasons, other statuses mean the request itself was rejected.
+func ReasonForStatus(status int) string {
+	switch {
+	case status == http.StatusNotFound:
+		return ReasonServerRespondedWithErrorNotFound
+	case status == http.StatusUnauthorized:
+		return ReasonAuthorizationRequired
+	case status == http.StatusForbidden:
+		return ReasonAccessDenied
+	case status == http.StatusTooManyRequests:
+		return ReasonTooManyRequests
+	case status == http.StatusBadGateway, status == http.StatusServiceUnavailable, status == http.StatusGatewayTimeout:
+		return ReasonServerUnavailable
+	case status >= 500:
+		return ReasonServerRespondedWithError
+	}
+	return ReasonServerRejectedRequest
+}
+
 type FrmClientError struct {
 	errs.BaseError
-	Err *Err
+	Err        *Err
+	StatusCode int
+	// RetryAfter is the delay requested by the server in the Retry-After header
+	RetryAfter time.Duration
+}
+
+// parseRetryAfter accepts both forms of the header: delay in seconds and HTTP date
+func parseRetryAfter(v string) time.Duration {
+	if len(v) == 0 {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(v); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && time.Until(t) > 0 {
+		return time.Until(t)
+	}
+	return 0
+}
+
+func newReasonError(reason string) *FrmClientError {
+	return &FrmClientError{
+		BaseError: *errs.NewBaseErrorWithReason(reason, reason),
+		Err:       &Err{Reason: reason, Message: reason},
+	}
+}
+
+func (e *FrmClientError) GetReason() string {
+	if e.Err != nil {
+		return e.Err.Reason
+	}
+	return e.Reason
+}
+
+// Is matches errors of the same reason, so errors.Is(err, ErrValidation) works for any validation failure
+func (e *FrmClientError) Is(target error) bool {
+	t, ok := target.(*FrmClientError)
+	return ok && e.GetReason() == t.GetReason()
 }
 
 type Err struct {
@@ -0,0 +1,326 @@
+package frmclient
+
+import (
+	"context"
+	"errors"
+	"github.com/itskovichanton/goava/pkg/goava/errs"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ReasonCircuitOpen = "ERRCODE_CIRCUIT_OPEN"
+
+	defaultPolicyMaxAttempts        = 3
+	defaultPolicyMinBackoff         = 200 * time.Millisecond
+	defaultPolicyMaxBackoff         = 10 * time.Second
+	defaultPolicyBreakerOpenTimeout = 30 * time.Second
+)
+
+var ErrCircuitOpen = newReasonError(ReasonCircuitOpen)
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// Policy controls retries and the circuit breaker of the calls to one endpoint.
+//
+// Only idempotent methods are retried unless RetryUnsafe is set. A failed attempt is retried when its reason
+// is registered as retryable, the server answered 5xx or the attempt timed out, and never after the context of the call ended.
+// Backoff grows exponentially with jitter, Retry-After of the server wins up to MaxBackoff:
+// a server asking to wait longer than that fails the call with its 429 or 503 error at once.
+type Policy struct {
+	// Timeout bounds the whole call including retries, AttemptTimeout each attempt
+	Timeout        time.Duration
+	AttemptTimeout time.Duration
+	MaxAttempts    int
+	MinBackoff     time.Duration
+	MaxBackoff     time.Duration
+	// BreakerThreshold consecutive upstream failures open the breaker, 0 disables it
+	BreakerThreshold int
+	// BreakerOpenTimeout is how long the breaker rejects calls before letting a single probe through
+	BreakerOpenTimeout time.Duration
+	// RetryUnsafe allows retries of POST and PATCH, set it only for endpoints that deduplicate requests
+	RetryUnsafe bool
+}
+
+// BreakerStateChange is reported to Client.OnBreakerStateChange
+type BreakerStateChange struct {
+	Endpoint string
+	From, To BreakerState
+	// Err is the failure that opened the breaker
+	Err error
+}
+
+type breaker struct {
+	lock     sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// execute runs attempt under the policy of url. attempt is called with a fresh request each time.
+func (c *Client) execute(ctx context.Context, method, rawUrl string, req interface{}, attempt func(r *http.Request) error) error {
+	endpoint, p := c.policyFor(rawUrl)
+	if p == nil {
+		return c.send(ctx, method, rawUrl, req, attempt)
+	}
+
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+	maxAttempts := p.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultPolicyMaxAttempts
+	}
+	if !replayable(req) {
+		maxAttempts = 1
+	}
+	b := c.breaker(endpoint)
+
+	var err error
+	for n := 1; ; n++ {
+		if openErr := c.allow(b, p, endpoint); openErr != nil {
+			if err != nil {
+				// the breaker opened during the retries, the caller gets the failure that opened it
+				return err
+			}
+			return openErr
+		}
+		err = c.attempt(ctx, method, rawUrl, req, p, attempt)
+		c.record(ctx, b, p, endpoint, err)
+		if err == nil || n >= maxAttempts || !shouldRetry(ctx, p, method, err) {
+			return err
+		}
+
+		wait := backoff(p, n)
+		var frmErr *FrmClientError
+		if errors.As(err, &frmErr) && frmErr.RetryAfter > 0 {
+			if frmErr.RetryAfter > maxBackoff(p) {
+				return err
+			}
+			wait = frmErr.RetryAfter
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, method, rawUrl string, req interface{}, p *Policy, attempt func(r *http.Request) error) error {
+	if p.AttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.AttemptTimeout)
+		defer cancel()
+	}
+	if s, ok := req.(io.Seeker); ok {
+		if _, err := s.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	return c.send(ctx, method, rawUrl, req, attempt)
+}
+
+func shouldRetry(ctx context.Context, p *Policy, method string, err error) bool {
+	if ctx.Err() != nil || !isIdempotent(method) && !p.RetryUnsafe {
+		return false
+	}
+	// ErrTimeout here is the end of AttemptTimeout, the call itself is still alive
+	if IsRetryable(err) || errors.Is(err, ErrTimeout) {
+		return true
+	}
+	var frmErr *FrmClientError
+	return errors.As(err, &frmErr) && frmErr.StatusCode >= 500
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// replayable reports whether req can be sent again, a plain io.Reader is consumed by the first attempt
+func replayable(req interface{}) bool {
+	if _, ok := req.(io.Reader); ok {
+		_, ok = req.(io.Seeker)
+		return ok
+	}
+	return true
+}
+
+func backoff(p *Policy, attempt int) time.Duration {
+	r, max := p.MinBackoff, maxBackoff(p)
+	if r <= 0 {
+		r = defaultPolicyMinBackoff
+	}
+	for i := 1; i < attempt && r < max; i++ {
+		r *= 2
+	}
+	if r > max {
+		r = max
+	}
+	// equal jitter keeps at least half of the delay and spreads the rest
+	return r/2 + time.Duration(rand.Int63n(int64(r/2)+1))
+}
+
+func maxBackoff(p *Policy) time.Duration {
+	if p.MaxBackoff <= 0 {
+		return defaultPolicyMaxBackoff
+	}
+	return p.MaxBackoff
+}
+
+// policyFor returns the policy of the longest matching Policies prefix, or the default Policy keyed by host
+func (c *Client) policyFor(rawUrl string) (string, *Policy) {
+	if c.Config == nil {
+		return "", nil
+	}
+	key := ""
+	for prefix := range c.Config.Policies {
+		if strings.HasPrefix(rawUrl, prefix) && len(prefix) > len(key) {
+			key = prefix
+		}
+	}
+	if len(key) > 0 {
+		return key, c.Config.Policies[key]
+	}
+	if c.Config.Policy == nil {
+		return "", nil
+	}
+	if u, err := url.Parse(c.resolve(rawUrl)); err == nil {
+		key = u.Host
+	}
+	return key, c.Config.Policy
+}
+
+func (c *Client) breaker(endpoint string) *breaker {
+	r, _ := c.breakers.LoadOrStore(endpoint, &breaker{state: BreakerClosed})
+	return r.(*breaker)
+}
+
+// BreakerState returns the state of the breaker of the endpoint (a Policies prefix or a host)
+func (c *Client) BreakerState(endpoint string) BreakerState {
+	b := c.breaker(endpoint)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.state
+}
+
+func (c *Client) allow(b *breaker, p *Policy, endpoint string) error {
+	if p.BreakerThreshold <= 0 {
+		return nil
+	}
+	openTimeout := p.BreakerOpenTimeout
+	if openTimeout <= 0 {
+		openTimeout = defaultPolicyBreakerOpenTimeout
+	}
+
+	b.lock.Lock()
+	var change *BreakerStateChange
+	allowed := true
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < openTimeout {
+			allowed = false
+			break
+		}
+		change = &BreakerStateChange{Endpoint: endpoint, From: BreakerOpen, To: BreakerHalfOpen}
+		b.state = BreakerHalfOpen
+		b.probing = true
+	case BreakerHalfOpen:
+		if b.probing {
+			allowed = false
+			break
+		}
+		b.probing = true
+	}
+	b.lock.Unlock()
+
+	c.reportStateChange(change)
+	if !allowed {
+		return &FrmClientError{
+			BaseError:  *errs.NewBaseErrorWithReason("circuit breaker is open for "+endpoint, ReasonCircuitOpen),
+			Err:        &Err{Reason: ReasonCircuitOpen, Message: "circuit breaker is open for " + endpoint},
+			StatusCode: http.StatusServiceUnavailable,
+		}
+	}
+	return nil
+}
+
+func (c *Client) record(ctx context.Context, b *breaker, p *Policy, endpoint string, err error) {
+	if p.BreakerThreshold <= 0 {
+		return
+	}
+	canceled := ctx.Err() == context.Canceled
+
+	b.lock.Lock()
+	var change *BreakerStateChange
+	from := b.state
+	b.probing = false
+	switch {
+	case isUpstreamFailure(err) && !canceled:
+		b.failures++
+		if b.state == BreakerHalfOpen || b.failures >= p.BreakerThreshold {
+			b.state = BreakerOpen
+			b.openedAt = time.Now()
+		}
+	case err == nil || isUpstreamAnswer(err) && !canceled:
+		b.failures = 0
+		b.state = BreakerClosed
+	default:
+		// the request was not sent or the caller gave up, the attempt says nothing about the upstream
+	}
+	if from != b.state {
+		change = &BreakerStateChange{Endpoint: endpoint, From: from, To: b.state, Err: err}
+	}
+	b.lock.Unlock()
+
+	c.reportStateChange(change)
+}
+
+// isUpstreamFailure tells the failures of the upstream itself from rejections of the request
+func isUpstreamFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	var frmErr *FrmClientError
+	if !errors.As(err, &frmErr) || frmErr.GetReason() == ReasonCanceled {
+		return false
+	}
+	return frmErr.StatusCode == 0 || frmErr.StatusCode >= 500
+}
+
+// isUpstreamAnswer reports whether err came from the upstream, so that the request reached it
+func isUpstreamAnswer(err error) bool {
+	var frmErr *FrmClientError
+	return errors.As(err, &frmErr) && frmErr.GetReason() != ReasonCanceled
+}
+
+func (c *Client) reportStateChange(change *BreakerStateChange) {
+	if change != nil && c.OnBreakerStateChange != nil {
+		c.OnBreakerStateChange(change)
+	}
+}
+
+func init() {
+	RegisterReason(&ReasonInfo{Reason: ReasonCircuitOpen, Status: http.StatusServiceUnavailable, LogLevel: LogLevelWarning})
+}
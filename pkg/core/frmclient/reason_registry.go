@@ -0,0 +1,101 @@
+package frmclient
+
+import (
+	"errors"
+	"github.com/itskovichanton/core/pkg/core/i18n"
+	"github.com/itskovichanton/goava/pkg/goava/errs"
+	"net/http"
+	"sync"
+)
+
+// Alert levels of ReasonInfo.LogLevel, the same scale as core.AlertParams.Level
+const (
+	LogLevelInfo     = 1
+	LogLevelWarning  = 2
+	LogLevelCritical = 3
+)
+
+// ReasonInfo describes how an error reason is reported to the caller and to the developers
+type ReasonInfo struct {
+	Reason string
+	// Status is the HTTP status of the response, statuses below 500 expose the error message to the caller
+	Status int
+	// Retryable tells the caller that the same request may succeed later
+	Retryable bool
+	LogLevel  int
+	// Messages are user messages by locale, RegisterReason adds them to i18n.Default as "reason.<Reason>"
+	Messages map[string]string
+}
+
+// Message returns the user message for locale, resolved by i18n.Default over its locale fallback chain
+func (r *ReasonInfo) Message(locale string) string {
+	if m, ok := i18n.Default.Lookup(locale, "reason."+r.Reason); ok {
+		return m
+	}
+	return i18n.Message(locale, "reason."+ReasonInternal)
+}
+
+var reasons = struct {
+	sync.RWMutex
+	m map[string]*ReasonInfo
+}{m: map[string]*ReasonInfo{}}
+
+// RegisterReason adds or replaces the description of a reason, apps register their own reasons at startup
+func RegisterReason(info *ReasonInfo) {
+	for locale, m := range info.Messages {
+		i18n.Default.Add(locale, map[string]string{"reason." + info.Reason: m})
+	}
+	reasons.Lock()
+	reasons.m[info.Reason] = info
+	reasons.Unlock()
+}
+
+func GetReasonInfo(reason string) (*ReasonInfo, bool) {
+	reasons.RLock()
+	defer reasons.RUnlock()
+	r, ok := reasons.m[reason]
+	return r, ok
+}
+
+// GetErrorReasonInfo finds the registered reason of err: FrmClientError reasons first, then errs.BaseError ones
+func GetErrorReasonInfo(err error) (*ReasonInfo, bool) {
+	var frmErr *FrmClientError
+	if errors.As(err, &frmErr) {
+		if r, ok := GetReasonInfo(frmErr.GetReason()); ok {
+			return r, ok
+		}
+	}
+	if baseErr := errs.FindBaseError(err); baseErr != nil {
+		return GetReasonInfo(baseErr.Reason)
+	}
+	return nil, false
+}
+
+// IsRetryable reports whether the reason of err is registered as retryable
+func IsRetryable(err error) bool {
+	r, ok := GetErrorReasonInfo(err)
+	return ok && r.Retryable
+}
+
+func init() {
+	for _, r := range []*ReasonInfo{
+		{Reason: ReasonValidation, Status: http.StatusBadRequest, Retryable: false, LogLevel: LogLevelInfo},
+		{Reason: ReasonAuthorizationRequired, Status: http.StatusUnauthorized, Retryable: false, LogLevel: LogLevelInfo},
+		{Reason: ReasonInactiveUser, Status: http.StatusForbidden, Retryable: false, LogLevel: LogLevelInfo},
+		{Reason: ReasonAccessDenied, Status: http.StatusForbidden, Retryable: false, LogLevel: LogLevelWarning},
+		{Reason: ReasonServerRespondedWithErrorNotFound, Status: http.StatusNotFound, Retryable: false, LogLevel: LogLevelInfo},
+		{Reason: ReasonCallerUpdateRequired, Status: http.StatusUpgradeRequired, Retryable: false, LogLevel: LogLevelInfo},
+		{Reason: ReasonTooManyRequests, Status: http.StatusTooManyRequests, Retryable: true, LogLevel: LogLevelWarning},
+		{Reason: ReasonServerRespondedWithError, Status: http.StatusBadGateway, Retryable: true, LogLevel: LogLevelWarning},
+		{Reason: ReasonServerRejectedRequest, Status: http.StatusBadGateway, Retryable: false, LogLevel: LogLevelWarning},
+		{Reason: ReasonServerUnavailable, Status: http.StatusServiceUnavailable, Retryable: true, LogLevel: LogLevelWarning},
+		{Reason: ReasonNetwork, Status: http.StatusBadGateway, Retryable: true, LogLevel: LogLevelWarning},
+		{Reason: ReasonTimeout, Status: http.StatusGatewayTimeout, Retryable: false, LogLevel: LogLevelWarning},
+		// 499 is the nginx status of a request closed by the client
+		{Reason: ReasonCanceled, Status: 499, Retryable: false, LogLevel: LogLevelInfo},
+		{Reason: ReasonTechnical, Status: http.StatusInternalServerError, Retryable: false, LogLevel: LogLevelCritical},
+		{Reason: ReasonInternal, Status: http.StatusInternalServerError, Retryable: false, LogLevel: LogLevelCritical},
+	} {
+		RegisterReason(r)
+	}
+}
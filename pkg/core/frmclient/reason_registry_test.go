@@ -0,0 +1,33 @@
+package frmclient
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestReasonMessageLocaleFallback(t *testing.T) {
+	RegisterReason(&ReasonInfo{
+		Reason:   "TEST_ORDER_CLOSED",
+		Status:   http.StatusConflict,
+		LogLevel: LogLevelInfo,
+		Messages: map[string]string{"ru": "Заказ закрыт", "en": "The order is closed"},
+	})
+	info, ok := GetReasonInfo("TEST_ORDER_CLOSED")
+	if !ok {
+		t.Fatal("reason is not registered")
+	}
+	for locale, expected := range map[string]string{
+		"en":    "The order is closed",
+		"en-US": "The order is closed",
+		"EN_gb": "The order is closed",
+		"de":    "Заказ закрыт",
+		"":      "Заказ закрыт",
+	} {
+		if m := info.Message(locale); m != expected {
+			t.Fatalf("%q: got %q, expected %q", locale, m, expected)
+		}
+	}
+	if m := (&ReasonInfo{Reason: ReasonAccessDenied}).Message("en-US"); m != "Access denied." {
+		t.Fatalf("built-in reason: got %q", m)
+	}
+}
@@ -7,9 +7,32 @@ const ReasonTechnical = "ERRCODE_TECHNICAL"
 const ReasonInternal = "INTERNAL"
 const ReasonServerRespondedWithError = "ERRCODE_SERVER_RESPONDED_WITH_ERROR"
 const ReasonServerRespondedWithErrorNotFound = "ERRCODE_SERVER_RESPONDED_WITH_ERROR_NOT_FOUND"
+const ReasonServerRejectedRequest = "ERRCODE_SERVER_REJECTED_REQUEST"
 const ReasonServerUnavailable = "ERRCODE_SERVER_UNAVAILABLE"
+const ReasonNetwork = "ERRCODE_NETWORK"
+const ReasonTimeout = "ERRCODE_TIMEOUT"
+const ReasonCanceled = "ERRCODE_CANCELED"
 const ReasonCallerUpdateRequired = "ERRCODE_CALLER_UPDATE_REQUIRED"
 const ReasonAccessDenied = "ERRCODE_ACCESS_DENIED"
 const ReasonTooManyRequests = "REASON_TO_MANY_REQUESTS"
 
 const InternalErrorMessage = "Произошла внутренняя ошибка. Мы уже занимаемся решением этой проблемы."
+
+// Typed errors for errors.Is, any *FrmClientError with the same reason matches them
+var (
+	ErrValidation                       = newReasonError(ReasonValidation)
+	ErrAuthorizationRequired            = newReasonError(ReasonAuthorizationRequired)
+	ErrInactiveUser                     = newReasonError(ReasonInactiveUser)
+	ErrTechnical                        = newReasonError(ReasonTechnical)
+	ErrInternal                         = newReasonError(ReasonInternal)
+	ErrServerRespondedWithError         = newReasonError(ReasonServerRespondedWithError)
+	ErrServerRespondedWithErrorNotFound = newReasonError(ReasonServerRespondedWithErrorNotFound)
+	ErrServerRejectedRequest            = newReasonError(ReasonServerRejectedRequest)
+	ErrServerUnavailable                = newReasonError(ReasonServerUnavailable)
+	ErrNetwork                          = newReasonError(ReasonNetwork)
+	ErrTimeout                          = newReasonError(ReasonTimeout)
+	ErrCanceled                         = newReasonError(ReasonCanceled)
+	ErrCallerUpdateRequired             = newReasonError(ReasonCallerUpdateRequired)
+	ErrAccessDenied                     = newReasonError(ReasonAccessDenied)
+	ErrTooManyRequests                  = newReasonError(ReasonTooManyRequests)
+)
@@ -0,0 +1,155 @@
+package frmserver
+
+import (
+	"context"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"github.com/itskovichanton/core/pkg/core/frmclient"
+	"github.com/itskovichanton/goava/pkg/goava/errs"
+	"github.com/patrickmn/go-cache"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxSkew     = 5 * time.Minute
+	defaultMaxBodySize = 10 << 20
+)
+
+// Verifier checks the credentials of a request and returns the caller, it is the counterpart of frmclient.Authenticator.
+// Missing or expired credentials are AUTHORIZATION_REQUIRED errors, invalid ones are ERRCODE_ACCESS_DENIED.
+type Verifier interface {
+	Verify(r *http.Request) (principal string, err error)
+}
+
+// BearerVerifier checks the bearer token of frmclient.BearerAuth and frmclient.TokenAuth
+type BearerVerifier struct {
+	// Validate returns the caller of token, or an error with the reason of the failure
+	Validate func(ctx context.Context, token string) (string, error)
+}
+
+func (v *BearerVerifier) Verify(r *http.Request) (string, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return "", authError(frmclient.ReasonAuthorizationRequired, "bearer token expected")
+	}
+	return v.Validate(r.Context(), token)
+}
+
+// StaticTokens is a BearerVerifier.Validate for a fixed set of tokens, keyed by token with callers as values
+func StaticTokens(tokens map[string]string) func(ctx context.Context, token string) (string, error) {
+	return func(ctx context.Context, token string) (string, error) {
+		if principal, ok := tokens[token]; ok {
+			return principal, nil
+		}
+		return "", authError(frmclient.ReasonAccessDenied, "unknown token")
+	}
+}
+
+// HMACVerifier checks the signature of frmclient.HMACAuth and rejects replayed nonces
+type HMACVerifier struct {
+	// Secrets by key id, the key id is returned as the caller
+	Secrets map[string]string
+	// MaxSkew is the allowed difference between the request timestamp and the server clock, 5 minutes by default
+	MaxSkew time.Duration
+	// MaxBodySize limits the body read to check the signature, 10 MB by default
+	MaxBodySize int64
+
+	noncesOnce sync.Once
+	nonces     *cache.Cache
+}
+
+func (v *HMACVerifier) Verify(r *http.Request) (string, error) {
+	keyId := r.Header.Get(frmclient.HeaderKeyId)
+	signature := r.Header.Get(frmclient.HeaderSignature)
+	if len(keyId) == 0 || len(signature) == 0 {
+		return "", authError(frmclient.ReasonAuthorizationRequired, "request signature expected")
+	}
+	secret, ok := v.Secrets[keyId]
+	if !ok {
+		return "", authError(frmclient.ReasonAccessDenied, "unknown key "+keyId)
+	}
+
+	maxSkew := v.maxSkew()
+	timestamp := r.Header.Get(frmclient.HeaderTimestamp)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", authError(frmclient.ReasonAccessDenied, "invalid timestamp")
+	}
+	if skew := time.Since(time.Unix(unix, 0)); skew > maxSkew || skew < -maxSkew {
+		return "", authError(frmclient.ReasonAuthorizationRequired, "request timestamp expired")
+	}
+
+	maxBodySize := v.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = defaultMaxBodySize
+	}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = http.MaxBytesReader(nil, r.Body, maxBodySize)
+	}
+	body, err := frmclient.ReadBody(r)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return "", authError(frmclient.ReasonValidation, fmt.Sprintf("request body exceeds %v bytes", maxBodySize))
+		}
+		return "", err
+	}
+	nonce := r.Header.Get(frmclient.HeaderNonce)
+	expected := frmclient.HMACSignature(secret, r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return "", authError(frmclient.ReasonAccessDenied, "invalid signature")
+	}
+
+	// a nonce is remembered while its timestamp is acceptable
+	if err = v.getNonces().Add(keyId+":"+nonce, true, 2*maxSkew); err != nil {
+		return "", authError(frmclient.ReasonAccessDenied, "replayed request")
+	}
+	return keyId, nil
+}
+
+func (v *HMACVerifier) maxSkew() time.Duration {
+	if v.MaxSkew <= 0 {
+		return defaultMaxSkew
+	}
+	return v.MaxSkew
+}
+
+func (v *HMACVerifier) getNonces() *cache.Cache {
+	v.noncesOnce.Do(func() { v.nonces = cache.New(2*v.maxSkew(), time.Minute) })
+	return v.nonces
+}
+
+// AnyVerifier picks the verifier by the credentials present: HMAC when the request is signed, bearer otherwise
+type AnyVerifier struct {
+	Bearer *BearerVerifier
+	HMAC   *HMACVerifier
+}
+
+func (v *AnyVerifier) Verify(r *http.Request) (string, error) {
+	if v.HMAC != nil && len(r.Header.Get(frmclient.HeaderSignature)) > 0 {
+		return v.HMAC.Verify(r)
+	}
+	if v.Bearer != nil {
+		if _, ok := bearerToken(r); ok {
+			return v.Bearer.Verify(r)
+		}
+	}
+	return "", authError(frmclient.ReasonAuthorizationRequired, "credentials expected")
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || len(token) == 0 {
+		return "", false
+	}
+	return token, true
+}
+
+func authError(reason, message string) error {
+	return errs.NewBaseErrorWithReason(message, reason)
+}
@@ -0,0 +1,162 @@
+package frmserver_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/itskovichanton/core/pkg/core"
+	"github.com/itskovichanton/core/pkg/core/frmclient"
+	"github.com/itskovichanton/core/pkg/core/frmserver"
+	"github.com/itskovichanton/core/pkg/core/frmtest"
+	"github.com/itskovichanton/goava/pkg/goava/errs"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+type echoRequest struct {
+	Text string `json:"text"`
+}
+
+// newServer serves POST /whoami answering the caller verified by auth
+func newServer(auth frmserver.Verifier) *frmtest.Server {
+	s := frmtest.NewServer()
+	s.Handle(http.MethodPost, "/whoami", frmserver.Handle(&frmserver.Adapter{Auth: auth}, func(ctx context.Context, req echoRequest) (string, error) {
+		return core.GetRequestMeta(ctx).User + ":" + req.Text, nil
+	}))
+	return s
+}
+
+func whoami(c *frmclient.Client, req interface{}) (string, error) {
+	return frmclient.CallWith[string](context.Background(), c, http.MethodPost, "/whoami", req)
+}
+
+func TestBearerAuth(t *testing.T) {
+	s := newServer(&frmserver.BearerVerifier{Validate: frmserver.StaticTokens(map[string]string{"secret-token": "bob"})})
+	defer s.Close()
+
+	for _, tc := range []struct {
+		name   string
+		auth   frmclient.Authenticator
+		expect error
+	}{
+		{"valid", &frmclient.BearerAuth{Token: "secret-token"}, nil},
+		{"invalid", &frmclient.BearerAuth{Token: "other-token"}, frmclient.ErrAccessDenied},
+		{"missing", nil, frmclient.ErrAuthorizationRequired},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &frmclient.Client{Config: &frmclient.Config{BaseUrl: s.URL}, Auth: tc.auth}
+			r, err := whoami(c, &echoRequest{Text: "hi"})
+			if tc.expect != nil {
+				if !errors.Is(err, tc.expect) {
+					t.Fatalf("%v expected, got %v", tc.expect, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r != "bob:hi" {
+				t.Fatalf("unexpected result %v", r)
+			}
+		})
+	}
+}
+
+func TestTokenAuthRefresh(t *testing.T) {
+	s := newServer(&frmserver.BearerVerifier{Validate: func(ctx context.Context, token string) (string, error) {
+		if token != "token-2" {
+			return "", errs.NewBaseErrorWithReason("token expired", frmclient.ReasonAuthorizationRequired)
+		}
+		return "bob", nil
+	}})
+	defer s.Close()
+
+	var fetches int32
+	auth := &frmclient.TokenAuth{Fetch: func(ctx context.Context) (string, error) {
+		n := atomic.AddInt32(&fetches, 1)
+		if n == 1 {
+			return "token-1", nil
+		}
+		return "token-2", nil
+	}}
+	c := &frmclient.Client{Config: &frmclient.Config{BaseUrl: s.URL}, Auth: auth}
+
+	var wg sync.WaitGroup
+	results := make([]error, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, results[i] = whoami(c, &echoRequest{Text: "hi"})
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range results {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Fatalf("the token must be fetched once and refreshed once, %v fetches done", n)
+	}
+}
+
+func TestHMACAuth(t *testing.T) {
+	s := newServer(&frmserver.HMACVerifier{Secrets: map[string]string{"app": "shared-secret"}, MaxBodySize: 1024})
+	defer s.Close()
+
+	c := &frmclient.Client{Config: &frmclient.Config{BaseUrl: s.URL, HMACKeyId: "app", HMACSecret: "shared-secret"}}
+	r, err := whoami(c, &echoRequest{Text: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != "app:hi" {
+		t.Fatalf("unexpected result %v", r)
+	}
+
+	c.Config.HMACSecret = "wrong-secret"
+	if _, err = whoami(c, &echoRequest{Text: "hi"}); !errors.Is(err, frmclient.ErrAccessDenied) {
+		t.Fatalf("access denied expected for a wrong secret, got %v", err)
+	}
+
+	c.Config.HMACSecret = "shared-secret"
+	if _, err = whoami(c, &echoRequest{Text: strings.Repeat("x", 2048)}); !errors.Is(err, frmclient.ErrValidation) {
+		t.Fatalf("validation error expected for a large body, got %v", err)
+	}
+
+	if _, err = whoami(c, io.MultiReader(strings.NewReader(`{"text":"hi"}`))); err == nil {
+		t.Fatal("a streamed body must not be signed")
+	}
+}
+
+func TestHMACNonceReplay(t *testing.T) {
+	s := newServer(&frmserver.HMACVerifier{Secrets: map[string]string{"app": "shared-secret"}})
+	defer s.Close()
+
+	c := &frmclient.Client{Config: &frmclient.Config{BaseUrl: s.URL, HMACKeyId: "app", HMACSecret: "shared-secret"}}
+	r, err := c.NewRequest(context.Background(), http.MethodPost, "/whoami", &echoRequest{Text: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := frmclient.ReadBody(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, expect := range []error{nil, frmclient.ErrAccessDenied} {
+		replay := r.Clone(context.Background())
+		replay.Body = io.NopCloser(bytes.NewReader(body))
+		resp, err := http.DefaultClient.Do(replay)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = frmclient.ReadResult(resp, nil)
+		if expect == nil && err != nil || expect != nil && !errors.Is(err, expect) {
+			t.Fatalf("attempt %v: %v expected, got %v", i+1, expect, err)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Package frmserver answers HTTP requests in the {result, error, executionTimeMs} envelope parsed by frmclient.
+package frmserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/itskovichanton/core/pkg/core"
+	"github.com/itskovichanton/core/pkg/core/frmclient"
+	"github.com/itskovichanton/core/pkg/core/i18n"
+	"github.com/itskovichanton/core/pkg/core/validation"
+	"github.com/itskovichanton/goava/pkg/goava/errs"
+	"github.com/mitchellh/mapstructure"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const maxFormMemory = 32 << 20
+
+type Adapter struct {
+	Config *core.Config
+	// ErrorHandler receives internal errors, may be nil
+	ErrorHandler core.IErrorHandler
+	// Auth verifies the caller before the request is decoded, the caller goes to core.RequestMeta.User. May be nil.
+	Auth Verifier
+}
+
+// Handle adapts f to http.Handler. The request is decoded from the JSON body, or from the query
+// and form fields (weakly typed, by json tags) for GET requests and forms.
+func Handle[Req any, Res any](a *Adapter, f func(ctx context.Context, req Req) (Res, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx := r.Context()
+		if len(i18n.GetLocale(ctx)) == 0 {
+			ctx = i18n.WithLocale(ctx, i18n.LocaleFromRequest(r))
+		}
+		var res Res
+		var err error
+		func() {
+			defer core.RecoverTo(func(panicErr error) { err = panicErr })
+			if a.Auth != nil {
+				var principal string
+				if principal, err = a.Auth.Verify(r); err != nil {
+					return
+				}
+				ctx = core.WithUser(ctx, principal)
+			}
+			var req Req
+			if err = decodeRequest(r, &req); err != nil {
+				return
+			}
+			res, err = f(ctx, req)
+		}()
+		a.write(ctx, w, res, err, start)
+	})
+}
+
+func (a *Adapter) write(ctx context.Context, w http.ResponseWriter, res interface{}, err error, start time.Time) {
+	result := &frmclient.Result{}
+	status := http.StatusOK
+	if err != nil {
+		status, result.Err = a.toErr(ctx, err)
+	} else {
+		result.Res = res
+	}
+	result.ExecutionTimeMs = time.Since(start).Milliseconds()
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+// toErr maps err to the response status and error of the envelope in the locale of ctx
+func (a *Adapter) toErr(ctx context.Context, err error) (int, *frmclient.Err) {
+	locale := i18n.GetLocale(ctx)
+	var validationErr *validation.ValidationError
+	if errors.As(err, &validationErr) {
+		return reasonStatus(frmclient.ReasonValidation), &frmclient.Err{
+			Reason:  frmclient.ReasonValidation,
+			Message: validationErr.LocalizedMessage(locale),
+			Details: validationErr.Param + ": " + validationErr.Reason,
+		}
+	}
+
+	info, registered := frmclient.GetErrorReasonInfo(err)
+	baseErr := errs.FindBaseError(err)
+	if registered && info.Status < 500 {
+		r := &frmclient.Err{Reason: info.Reason}
+		var frmErr *frmclient.FrmClientError
+		if errors.As(err, &frmErr) && frmErr.Err != nil {
+			r.Message, r.Details = frmErr.Err.Message, frmErr.Err.Details
+		} else if baseErr != nil {
+			r.Message, r.Details = baseErr.Message, baseErr.Details
+		}
+		return info.Status, r
+	}
+
+	if !registered {
+		info, _ = frmclient.GetReasonInfo(frmclient.ReasonInternal)
+	}
+	if a.ErrorHandler != nil {
+		a.ErrorHandler.HandleCtx(ctx, err, true)
+	}
+	r := &frmclient.Err{Reason: info.Reason, Message: info.Message(locale)}
+	if a.Config == nil || !a.Config.IsProfileProd() {
+		r.Message = err.Error()
+		if baseErr != nil {
+			r.Details = baseErr.Details
+		}
+		if cause := errors.Unwrap(err); cause != nil {
+			r.Cause = cause.Error()
+		}
+	}
+	return info.Status, r
+}
+
+func reasonStatus(reason string) int {
+	if info, ok := frmclient.GetReasonInfo(reason); ok {
+		return info.Status
+	}
+	return http.StatusInternalServerError
+}
+
+func decodeRequest(r *http.Request, req interface{}) error {
+	contentType := r.Header.Get("Content-Type")
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete && strings.HasPrefix(contentType, "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return validationError("body", err)
+		}
+		return nil
+	}
+
+	if strings.HasPrefix(contentType, "multipart/form-data") {
+		if err := r.ParseMultipartForm(maxFormMemory); err != nil {
+			return validationError("body", err)
+		}
+	} else if err := r.ParseForm(); err != nil {
+		return validationError("body", err)
+	}
+	fields := map[string]interface{}{}
+	for k, v := range r.Form {
+		if len(v) == 1 {
+			fields[k] = v[0]
+		} else {
+			fields[k] = v
+		}
+	}
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:           req,
+		TagName:          "json",
+		WeaklyTypedInput: true,
+		DecodeHook:       mapstructure.StringToTimeDurationHookFunc(),
+	})
+	if err != nil {
+		return err
+	}
+	if err = decoder.Decode(fields); err != nil {
+		return validationError("params", err)
+	}
+	return nil
+}
+
+func validationError(param string, err error) error {
+	return &validation.ValidationError{
+		BaseError: *errs.NewBaseErrorFromCauseMsg(err, err.Error()),
+		Reason:    validation.Unexpectable,
+		Param:     param,
+	}
+}
@@ -0,0 +1,243 @@
+// Package frmtest provides a fake Frm server for contract tests of code using frmclient or frmserver.
+//
+//	s := frmtest.NewServer()
+//	defer s.Close()
+//	s.Reply("GET", "/user", &User{Name: "bob"}).WithLatency(50 * time.Millisecond)
+//	s.ReplyError("POST", "/user", frmclient.ReasonValidation, "name is empty")
+//	s.Handle("GET", "/orders", frmserver.Handle(adapter, getOrders)).RateLimitNext(2, time.Second)
+//	...
+//	s.RequestsTo("/user")
+package frmtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/itskovichanton/core/pkg/core/frmclient"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Request is a request recorded by Server
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   []byte
+	At     time.Time
+}
+
+// DecodeJSON unmarshals the body of the request into v
+func (r *Request) DecodeJSON(v interface{}) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+type Server struct {
+	*httptest.Server
+
+	lock     sync.Mutex
+	routes   map[string]*Route
+	requests []*Request
+}
+
+// Route is the handler of a method and path with the simulated misbehaviour configured for it
+type Route struct {
+	handler http.Handler
+
+	lock     sync.Mutex
+	latency  time.Duration
+	failures []http.HandlerFunc
+}
+
+func NewServer() *Server {
+	s := &Server{routes: map[string]*Route{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Handle registers h for method and path, an empty method matches any method
+func (s *Server) Handle(method, path string, h http.Handler) *Route {
+	r := &Route{handler: h}
+	s.lock.Lock()
+	s.routes[method+" "+path] = r
+	s.lock.Unlock()
+	return r
+}
+
+// HandleFunc answers with the result of f in the Result envelope, errors are mapped by their registered reasons
+func (s *Server) HandleFunc(method, path string, f func(r *Request) (interface{}, error)) *Route {
+	return s.Handle(method, path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res, err := f(requestFrom(r))
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		WriteResult(w, http.StatusOK, &frmclient.Result{Res: res})
+	}))
+}
+
+func (s *Server) Reply(method, path string, result interface{}) *Route {
+	return s.HandleFunc(method, path, func(r *Request) (interface{}, error) {
+		return result, nil
+	})
+}
+
+func (s *Server) ReplyError(method, path, reason, message string) *Route {
+	return s.Handle(method, path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReason(w, reason, message, 0)
+	}))
+}
+
+// Requests returns all recorded requests in the order they arrived
+func (s *Server) Requests() []*Request {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]*Request{}, s.requests...)
+}
+
+func (s *Server) RequestsTo(path string) []*Request {
+	var r []*Request
+	for _, req := range s.Requests() {
+		if req.Path == path {
+			r = append(r, req)
+		}
+	}
+	return r
+}
+
+// Reset forgets the routes and the recorded requests
+func (s *Server) Reset() {
+	s.lock.Lock()
+	s.routes = map[string]*Route{}
+	s.requests = nil
+	s.lock.Unlock()
+}
+
+// WithLatency delays every answer of the route
+func (r *Route) WithLatency(d time.Duration) *Route {
+	r.lock.Lock()
+	r.latency = d
+	r.lock.Unlock()
+	return r
+}
+
+// FailNext answers the next n requests with status and no envelope, as a proxy in front of a dead upstream does
+func (r *Route) FailNext(n int, status int) *Route {
+	return r.next(n, func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(status)
+	})
+}
+
+// FailNextWithReason answers the next n requests with an error of reason
+func (r *Route) FailNextWithReason(n int, reason, message string) *Route {
+	return r.next(n, func(w http.ResponseWriter, _ *http.Request) {
+		writeReason(w, reason, message, 0)
+	})
+}
+
+// RateLimitNext answers the next n requests with 429 and Retry-After
+func (r *Route) RateLimitNext(n int, retryAfter time.Duration) *Route {
+	return r.next(n, func(w http.ResponseWriter, _ *http.Request) {
+		writeReason(w, frmclient.ReasonTooManyRequests, "too many requests", retryAfter)
+	})
+}
+
+func (r *Route) next(n int, f http.HandlerFunc) *Route {
+	r.lock.Lock()
+	for i := 0; i < n; i++ {
+		r.failures = append(r.failures, f)
+	}
+	r.lock.Unlock()
+	return r
+}
+
+func (r *Route) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	r.lock.Lock()
+	latency := r.latency
+	var failure http.HandlerFunc
+	if len(r.failures) > 0 {
+		failure, r.failures = r.failures[0], r.failures[1:]
+	}
+	r.lock.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-req.Context().Done():
+			return
+		}
+	}
+	if failure != nil {
+		failure(w, req)
+		return
+	}
+	r.handler.ServeHTTP(w, req)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	s.lock.Lock()
+	s.requests = append(s.requests, &Request{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.Query(),
+		Header: r.Header.Clone(),
+		Body:   body,
+		At:     time.Now(),
+	})
+	route, ok := s.routes[r.Method+" "+r.URL.Path]
+	if !ok {
+		route, ok = s.routes[" "+r.URL.Path]
+	}
+	s.lock.Unlock()
+
+	if !ok {
+		writeReason(w, frmclient.ReasonServerRespondedWithErrorNotFound, "no route for "+r.Method+" "+r.URL.Path, 0)
+		return
+	}
+	route.serveHTTP(w, r)
+}
+
+func requestFrom(r *http.Request) *Request {
+	body, _ := io.ReadAll(r.Body)
+	return &Request{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.Query(),
+		Header: r.Header,
+		Body:   body,
+		At:     time.Now(),
+	}
+}
+
+// WriteError writes err in the envelope with the status of its registered reason, INTERNAL when it has none
+func WriteError(w http.ResponseWriter, err error) {
+	info, ok := frmclient.GetErrorReasonInfo(err)
+	if !ok {
+		info, _ = frmclient.GetReasonInfo(frmclient.ReasonInternal)
+	}
+	WriteResult(w, info.Status, &frmclient.Result{Err: &frmclient.Err{Reason: info.Reason, Message: err.Error()}})
+}
+
+func WriteResult(w http.ResponseWriter, status int, result *frmclient.Result) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+func writeReason(w http.ResponseWriter, reason, message string, retryAfter time.Duration) {
+	status := http.StatusInternalServerError
+	if info, ok := frmclient.GetReasonInfo(reason); ok {
+		status = info.Status
+	}
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int((retryAfter+time.Second-1)/time.Second)))
+	}
+	WriteResult(w, status, &frmclient.Result{Err: &frmclient.Err{Reason: reason, Message: message}})
+}
@@ -0,0 +1,163 @@
+package frmtest_test
+
+import (
+	"context"
+	"errors"
+	"github.com/itskovichanton/core/pkg/core/frmclient"
+	"github.com/itskovichanton/core/pkg/core/frmserver"
+	"github.com/itskovichanton/core/pkg/core/frmtest"
+	"github.com/itskovichanton/core/pkg/core/validation"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type user struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+type sumRequest struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+func newClient(s *frmtest.Server, policy *frmclient.Policy) *frmclient.Client {
+	return &frmclient.Client{
+		Config:     &frmclient.Config{BaseUrl: s.URL, Policy: policy},
+		HttpClient: s.Client(),
+	}
+}
+
+func retryPolicy() *frmclient.Policy {
+	return &frmclient.Policy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+}
+
+func TestReply(t *testing.T) {
+	s := frmtest.NewServer()
+	defer s.Close()
+	s.Reply(http.MethodGet, "/user", &user{Name: "bob", Age: 42})
+
+	r, err := frmclient.CallWith[user](context.Background(), newClient(s, nil), http.MethodGet, "/user", map[string]string{"id": "7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Name != "bob" || r.Age != 42 {
+		t.Fatalf("unexpected result %+v", r)
+	}
+	requests := s.RequestsTo("/user")
+	if len(requests) != 1 || requests[0].Query.Get("id") != "7" {
+		t.Fatalf("unexpected requests %+v", requests)
+	}
+}
+
+func TestReplyError(t *testing.T) {
+	s := frmtest.NewServer()
+	defer s.Close()
+	s.ReplyError(http.MethodGet, "/user", frmclient.ReasonValidation, "name is empty")
+
+	_, err := frmclient.CallWith[user](context.Background(), newClient(s, retryPolicy()), http.MethodGet, "/user", nil)
+	if !errors.Is(err, frmclient.ErrValidation) {
+		t.Fatalf("validation error expected, got %v", err)
+	}
+	var frmErr *frmclient.FrmClientError
+	if !errors.As(err, &frmErr) || frmErr.StatusCode != http.StatusBadRequest || frmErr.Err.Message != "name is empty" {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if n := len(s.RequestsTo("/user")); n != 1 {
+		t.Fatalf("validation errors must not be retried, %v requests sent", n)
+	}
+}
+
+func TestFailNextIsRetried(t *testing.T) {
+	s := frmtest.NewServer()
+	defer s.Close()
+	s.Reply(http.MethodGet, "/user", &user{Name: "bob"}).FailNext(2, http.StatusServiceUnavailable)
+
+	r, err := frmclient.CallWith[user](context.Background(), newClient(s, retryPolicy()), http.MethodGet, "/user", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Name != "bob" {
+		t.Fatalf("unexpected result %+v", r)
+	}
+	if n := len(s.RequestsTo("/user")); n != 3 {
+		t.Fatalf("3 requests expected, %v sent", n)
+	}
+}
+
+func TestFailNextIsNotRetriedForUnsafeMethods(t *testing.T) {
+	s := frmtest.NewServer()
+	defer s.Close()
+	s.Reply(http.MethodPost, "/user", &user{Name: "bob"}).FailNext(1, http.StatusServiceUnavailable)
+
+	_, err := frmclient.CallWith[user](context.Background(), newClient(s, retryPolicy()), http.MethodPost, "/user", &user{Name: "bob"})
+	if !errors.Is(err, frmclient.ErrServerUnavailable) {
+		t.Fatalf("server unavailable expected, got %v", err)
+	}
+	if n := len(s.RequestsTo("/user")); n != 1 {
+		t.Fatalf("POST must not be retried, %v requests sent", n)
+	}
+}
+
+func TestRateLimitNext(t *testing.T) {
+	s := frmtest.NewServer()
+	defer s.Close()
+	s.Reply(http.MethodGet, "/user", &user{}).RateLimitNext(1, 2*time.Second)
+
+	_, err := frmclient.CallWith[user](context.Background(), newClient(s, nil), http.MethodGet, "/user", nil)
+	var frmErr *frmclient.FrmClientError
+	if !errors.As(err, &frmErr) || !errors.Is(err, frmclient.ErrTooManyRequests) || frmErr.RetryAfter != 2*time.Second {
+		t.Fatalf("rate limit with Retry-After expected, got %+v", err)
+	}
+	if _, err = frmclient.CallWith[user](context.Background(), newClient(s, nil), http.MethodGet, "/user", nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRetryAfterAboveMaxBackoffIsNotWaited(t *testing.T) {
+	s := frmtest.NewServer()
+	defer s.Close()
+	s.Reply(http.MethodGet, "/user", &user{}).RateLimitNext(1, time.Hour)
+
+	started := time.Now()
+	_, err := frmclient.CallWith[user](context.Background(), newClient(s, retryPolicy()), http.MethodGet, "/user", nil)
+	if !errors.Is(err, frmclient.ErrTooManyRequests) {
+		t.Fatalf("rate limit expected, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Fatalf("the call must give up at once, it took %v", elapsed)
+	}
+	if n := len(s.RequestsTo("/user")); n != 1 {
+		t.Fatalf("1 request expected, %v sent", n)
+	}
+}
+
+func TestAdapterRoundTrip(t *testing.T) {
+	s := frmtest.NewServer()
+	defer s.Close()
+	s.Handle(http.MethodPost, "/sum", frmserver.Handle(&frmserver.Adapter{}, func(ctx context.Context, req sumRequest) (int, error) {
+		if req.B < 0 {
+			_, err := validation.CheckCondition(func() (interface{}, bool) { return nil, false }, "b", validation.Unexpectable, req.B, func() string {
+				return "b must not be negative"
+			})
+			return 0, err
+		}
+		return req.A + req.B, nil
+	}))
+	c := newClient(s, nil)
+
+	r, err := frmclient.CallWith[int](context.Background(), c, http.MethodPost, "/sum", &sumRequest{A: 2, B: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != 5 {
+		t.Fatalf("5 expected, got %v", r)
+	}
+
+	_, err = frmclient.CallWith[int](context.Background(), c, http.MethodPost, "/sum", &sumRequest{A: 2, B: -1})
+	var frmErr *frmclient.FrmClientError
+	if !errors.As(err, &frmErr) || !errors.Is(err, frmclient.ErrValidation) || frmErr.Err.Message != "b must not be negative" {
+		t.Fatalf("validation error expected, got %+v", err)
+	}
+}
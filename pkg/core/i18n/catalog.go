@@ -0,0 +1,115 @@
+// Package i18n holds the message catalog used for user-facing texts of validation, error envelopes and emails.
+package i18n
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const DefaultLocale = "ru"
+
+// Catalog maps message keys to texts by locale. A missing text falls back to the language of the locale
+// ("en" for "en-US"), then to DefaultLocale, then to the key itself.
+type Catalog struct {
+	DefaultLocale string
+
+	lock     sync.RWMutex
+	messages map[string]map[string]string
+}
+
+// Default is the catalog of the app, it contains the built-in messages of the core packages
+var Default = NewCatalog(DefaultLocale)
+
+func NewCatalog(defaultLocale string) *Catalog {
+	return &Catalog{DefaultLocale: defaultLocale, messages: map[string]map[string]string{}}
+}
+
+// Add adds or replaces messages of locale
+func (c *Catalog) Add(locale string, messages map[string]string) {
+	locale = Normalize(locale)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	m := c.messages[locale]
+	if m == nil {
+		m = map[string]string{}
+		c.messages[locale] = m
+	}
+	for k, v := range messages {
+		m[k] = v
+	}
+}
+
+// LoadDir adds every <locale>.json file of dir, a flat JSON object of keys and texts. A missing dir is not an error.
+func (c *Catalog) LoadDir(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, fileName := range files {
+		data, err := os.ReadFile(fileName)
+		if err != nil {
+			return err
+		}
+		var messages map[string]string
+		if err = json.Unmarshal(data, &messages); err != nil {
+			return fmt.Errorf("%v: %w", fileName, err)
+		}
+		c.Add(strings.TrimSuffix(filepath.Base(fileName), ".json"), messages)
+	}
+	return nil
+}
+
+// Lookup returns the text of key for locale following the fallback chain
+func (c *Catalog) Lookup(locale, key string) (string, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, l := range c.fallbacks(locale) {
+		if m, ok := c.messages[l][key]; ok {
+			return m, true
+		}
+	}
+	return "", false
+}
+
+// Message returns the text of key formatted with args by fmt.Sprintf, the key itself when there is no text
+func (c *Catalog) Message(locale, key string, args ...interface{}) string {
+	m, ok := c.Lookup(locale, key)
+	if !ok {
+		m = key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(m, args...)
+	}
+	return m
+}
+
+func (c *Catalog) fallbacks(locale string) []string {
+	return Fallbacks(locale, c.DefaultLocale)
+}
+
+// Fallbacks returns the normalized locales to look a text up in: locale, its language and defaultLocale
+func Fallbacks(locale, defaultLocale string) []string {
+	locale = Normalize(locale)
+	var r []string
+	if len(locale) > 0 {
+		r = append(r, locale)
+		if lang, _, ok := strings.Cut(locale, "-"); ok {
+			r = append(r, lang)
+		}
+	}
+	return append(r, Normalize(defaultLocale))
+}
+
+// Normalize turns "en_US" and "EN-us" into "en-us"
+func Normalize(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
+
+// Message formats key of the Default catalog
+func Message(locale, key string, args ...interface{}) string {
+	return Default.Message(locale, key, args...)
+}
@@ -0,0 +1,38 @@
+package i18n
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+const LocaleHeader = "X-Locale"
+
+type localeKey struct{}
+
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeKey{}, locale)
+}
+
+// GetLocale returns the locale stored by WithLocale, "" when there is none
+func GetLocale(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	r, _ := ctx.Value(localeKey{}).(string)
+	return r
+}
+
+// LocaleFromRequest takes the locale from the X-Locale header, then from the first Accept-Language tag
+func LocaleFromRequest(r *http.Request) string {
+	if l := r.Header.Get(LocaleHeader); len(l) > 0 {
+		return l
+	}
+	tag, _, _ := strings.Cut(r.Header.Get("Accept-Language"), ",")
+	tag, _, _ = strings.Cut(tag, ";")
+	tag = strings.TrimSpace(tag)
+	if tag == "*" {
+		return ""
+	}
+	return tag
+}
@@ -0,0 +1,69 @@
+package i18n
+
+// Built-in messages of the core packages, apps override them with files loaded by LoadDir
+func init() {
+	Default.Add("ru", map[string]string{
+		"validation.int":            "Параметр должен быть целым числом",
+		"validation.float":          "Параметр должен быть вещественным числом",
+		"validation.notEmptyStr":    "Параметр должен быть непустой строкой",
+		"validation.notEmpty":       "Параметр не должен быть пустым",
+		"validation.bool":           "Параметр должен иметь значение true/false",
+		"validation.regexp":         "Параметр не соответствует формату",
+		"validation.date":           "Параметр должен быть датой",
+		"validation.maxLen":         "Длина строки превышает %v символов",
+		"validation.minLen":         "Длина строки меньше %v символов",
+		"validation.email":          "Некорректный e-mail",
+		"validation.noRecipients":   "Не указаны получатели",
+		"validation.reservedHeader": "Заголовок %v нельзя переопределить",
+		"validation.headerNewline":  "Значение заголовка не должно содержать перевод строки",
+
+		"reason.VALIDATION":                                    "Неверные параметры запроса.",
+		"reason.AUTHORIZATION_REQUIRED":                        "Требуется авторизация.",
+		"reason.INACTIVE_USER":                                 "Пользователь заблокирован.",
+		"reason.ERRCODE_ACCESS_DENIED":                         "Доступ запрещен.",
+		"reason.ERRCODE_SERVER_RESPONDED_WITH_ERROR_NOT_FOUND": "Не найдено.",
+		"reason.ERRCODE_CALLER_UPDATE_REQUIRED":                "Требуется обновить приложение.",
+		"reason.REASON_TO_MANY_REQUESTS":                       "Слишком много запросов, повторите позже.",
+		"reason.ERRCODE_SERVER_RESPONDED_WITH_ERROR":           "Сервер ответил ошибкой.",
+		"reason.ERRCODE_SERVER_REJECTED_REQUEST":               "Сервер отклонил запрос.",
+		"reason.ERRCODE_SERVER_UNAVAILABLE":                    "Сервер недоступен, повторите позже.",
+		"reason.ERRCODE_NETWORK":                               "Ошибка сети, повторите позже.",
+		"reason.ERRCODE_TIMEOUT":                               "Сервер не ответил вовремя.",
+		"reason.ERRCODE_CANCELED":                              "Запрос отменен.",
+		"reason.ERRCODE_CIRCUIT_OPEN":                          "Сервис временно недоступен, повторите позже.",
+		"reason.ERRCODE_TECHNICAL":                             "Произошла внутренняя ошибка. Мы уже занимаемся решением этой проблемы.",
+		"reason.INTERNAL":                                      "Произошла внутренняя ошибка. Мы уже занимаемся решением этой проблемы.",
+	})
+	Default.Add("en", map[string]string{
+		"validation.int":            "The parameter must be an integer",
+		"validation.float":          "The parameter must be a number",
+		"validation.notEmptyStr":    "The parameter must be a non-empty string",
+		"validation.notEmpty":       "The parameter must not be empty",
+		"validation.bool":           "The parameter must be true or false",
+		"validation.regexp":         "The parameter does not match the format",
+		"validation.date":           "The parameter must be a date",
+		"validation.maxLen":         "The string is longer than %v characters",
+		"validation.minLen":         "The string is shorter than %v characters",
+		"validation.email":          "Invalid e-mail",
+		"validation.noRecipients":   "No recipients specified",
+		"validation.reservedHeader": "The %v header can't be overridden",
+		"validation.headerNewline":  "A header value must not contain line breaks",
+
+		"reason.VALIDATION":                                    "Invalid request parameters.",
+		"reason.AUTHORIZATION_REQUIRED":                        "Authorization required.",
+		"reason.INACTIVE_USER":                                 "The user is inactive.",
+		"reason.ERRCODE_ACCESS_DENIED":                         "Access denied.",
+		"reason.ERRCODE_SERVER_RESPONDED_WITH_ERROR_NOT_FOUND": "Not found.",
+		"reason.ERRCODE_CALLER_UPDATE_REQUIRED":                "Please update the application.",
+		"reason.REASON_TO_MANY_REQUESTS":                       "Too many requests, try again later.",
+		"reason.ERRCODE_SERVER_RESPONDED_WITH_ERROR":           "The server responded with an error.",
+		"reason.ERRCODE_SERVER_REJECTED_REQUEST":               "The server rejected the request.",
+		"reason.ERRCODE_SERVER_UNAVAILABLE":                    "The server is unavailable, try again later.",
+		"reason.ERRCODE_NETWORK":                               "Network error, try again later.",
+		"reason.ERRCODE_TIMEOUT":                               "The server did not respond in time.",
+		"reason.ERRCODE_CANCELED":                              "The request was canceled.",
+		"reason.ERRCODE_CIRCUIT_OPEN":                          "The service is temporarily unavailable, try again later.",
+		"reason.ERRCODE_TECHNICAL":                             "An internal error occurred. We are already working on it.",
+		"reason.INTERNAL":                                      "An internal error occurred. We are already working on it.",
+	})
+}
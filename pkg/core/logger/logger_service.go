@@ -1,6 +1,7 @@
 package logger
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/itskovichanton/core/pkg/core"
@@ -167,6 +168,23 @@ func Subject(ld map[string]interface{}, sbj interface{}) map[string]interface{}
 	return Field(ld, "sbj", sbj)
 }
 
+func Meta(ld map[string]interface{}, ctx context.Context) map[string]interface{} {
+	m := core.GetRequestMeta(ctx)
+	if m == nil {
+		return ld
+	}
+	if len(m.CorrelationID) > 0 {
+		Field(ld, "cid", m.CorrelationID)
+	}
+	if len(m.User) > 0 {
+		Field(ld, "u", m.User)
+	}
+	if len(m.Action) > 0 {
+		Action(ld, m.Action)
+	}
+	return ld
+}
+
 func NewLD() map[string]interface{} {
 	return map[string]interface{}{}
 }
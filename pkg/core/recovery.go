@@ -0,0 +1,150 @@
+package core
+
+import (
+	"fmt"
+	"github.com/itskovichanton/goava/pkg/goava/errs"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+const ReasonPanic = "PANIC"
+
+type PanicError struct {
+	errs.BaseError
+
+	Value interface{}
+}
+
+func NewPanicError(v interface{}) *PanicError {
+	if e, ok := v.(*PanicError); ok {
+		return e
+	}
+	r := &PanicError{
+		BaseError: *errs.NewBaseErrorWithReasonDetails(fmt.Sprintf("panic: %v", v), string(debug.Stack()), ReasonPanic),
+		Value:     v,
+	}
+	if cause, ok := v.(error); ok {
+		r.Cause = cause
+	}
+	return r
+}
+
+// RecoverTo must be deferred. It converts a panic into *PanicError and passes it to onPanic.
+// With a nil onPanic the panic only goes to the standard logger, pass IPanicHandler.OnPanic to get alerts.
+func RecoverTo(onPanic func(err error)) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	err := NewPanicError(v)
+	if onPanic == nil {
+		log.Println(err.Error() + "\n" + err.Details)
+		return
+	}
+	onPanic(err)
+}
+
+func Go(f func(), onPanic func(err error)) {
+	go func() {
+		defer RecoverTo(onPanic)
+		f()
+	}()
+}
+
+type IPanicHandler interface {
+	Go(f func())
+	Recover()
+	Middleware(next http.Handler) http.Handler
+	OnPanic(err error)
+}
+
+type PanicHandlerImpl struct {
+	IPanicHandler
+
+	ErrorHandler IErrorHandler
+	Config       *Config
+	Level        int
+	ByFR         bool
+	RePanic      bool
+}
+
+func (c *PanicHandlerImpl) Init() {
+	c.Level = c.Config.GetInt("alerts", "panic", "level")
+	if c.Level == 0 {
+		c.Level = 3
+	}
+	c.ByFR = c.Config.GetBoolWithDefaultValue(true, "alerts", "panic", "byfr")
+	c.RePanic = c.Config.GetBool("alerts", "panic", "repanic")
+}
+
+func (c *PanicHandlerImpl) OnPanic(err error) {
+	c.ErrorHandler.HandleWithCustomParams(err, func(p *AlertParams) {
+		p.Level = c.Level
+		p.ByFR = c.ByFR
+	})
+	if c.RePanic {
+		panic(err)
+	}
+}
+
+func (c *PanicHandlerImpl) Go(f func()) {
+	Go(f, c.OnPanic)
+}
+
+// Recover must be deferred directly: defer panicHandler.Recover()
+func (c *PanicHandlerImpl) Recover() {
+	v := recover()
+	if v == nil {
+		return
+	}
+	c.OnPanic(NewPanicError(v))
+}
+
+func (c *PanicHandlerImpl) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &headerTrackingWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			// the status can't be changed once the response has started
+			if !rw.wroteHeader {
+				rw.WriteHeader(http.StatusInternalServerError)
+			}
+			c.OnPanic(NewPanicError(v))
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// headerTrackingWriter remembers whether the response has started
+type headerTrackingWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *headerTrackingWriter) WriteHeader(status int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *headerTrackingWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *headerTrackingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.wroteHeader = true
+		f.Flush()
+	}
+}
+
+func (w *headerTrackingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
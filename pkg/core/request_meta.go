@@ -0,0 +1,65 @@
+package core
+
+import "context"
+
+type requestMetaKey struct{}
+
+type RequestMeta struct {
+	CorrelationID string `json:"correlationId,omitempty"`
+	User          string `json:"user,omitempty"`
+	Action        string `json:"action,omitempty"`
+}
+
+func (m *RequestMeta) IsEmpty() bool {
+	return m == nil || len(m.CorrelationID)+len(m.User)+len(m.Action) == 0
+}
+
+func (m *RequestMeta) String() string {
+	if m.IsEmpty() {
+		return ""
+	}
+	r := ""
+	if len(m.CorrelationID) > 0 {
+		r += "CorrelationID: " + m.CorrelationID + "\n"
+	}
+	if len(m.User) > 0 {
+		r += "User: " + m.User + "\n"
+	}
+	if len(m.Action) > 0 {
+		r += "Action: " + m.Action + "\n"
+	}
+	return r
+}
+
+func GetRequestMeta(ctx context.Context) *RequestMeta {
+	if ctx == nil {
+		return nil
+	}
+	m, _ := ctx.Value(requestMetaKey{}).(*RequestMeta)
+	return m
+}
+
+func WithRequestMeta(ctx context.Context, m *RequestMeta) context.Context {
+	return context.WithValue(ctx, requestMetaKey{}, m)
+}
+
+func WithCorrelationID(ctx context.Context, correlationID string) context.Context {
+	return withRequestMetaField(ctx, func(m *RequestMeta) { m.CorrelationID = correlationID })
+}
+
+func WithUser(ctx context.Context, user string) context.Context {
+	return withRequestMetaField(ctx, func(m *RequestMeta) { m.User = user })
+}
+
+func WithAction(ctx context.Context, action string) context.Context {
+	return withRequestMetaField(ctx, func(m *RequestMeta) { m.Action = action })
+}
+
+func withRequestMetaField(ctx context.Context, setter func(m *RequestMeta)) context.Context {
+	m := &RequestMeta{}
+	if prev := GetRequestMeta(ctx); prev != nil {
+		*m = *prev
+	}
+	setter(m)
+	return WithRequestMeta(ctx, m)
+}
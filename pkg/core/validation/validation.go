@@ -1,7 +1,7 @@
 package validation
 
 import (
-	"fmt"
+	"github.com/itskovichanton/core/pkg/core/i18n"
 	"github.com/itskovichanton/goava/pkg/goava/errs"
 	"github.com/itskovichanton/goava/pkg/goava/utils"
 	"github.com/spf13/cast"
@@ -32,15 +32,24 @@ type ValidationError struct {
 	Reason       string
 	Param        string
 	InvalidValue interface{}
+	// MessageKey and MessageArgs are the i18n catalog message of the error, empty for custom messages
+	MessageKey  string
+	MessageArgs []interface{}
+}
+
+// LocalizedMessage returns the message for locale, custom messages are returned as is
+func (e *ValidationError) LocalizedMessage(locale string) string {
+	if len(e.MessageKey) == 0 {
+		return e.Message
+	}
+	return i18n.Message(locale, e.MessageKey, e.MessageArgs...)
 }
 
 func CheckInt64(param string, v interface{}) (int64, error) {
-	r, err := CheckCondition(func() (interface{}, bool) {
+	r, err := CheckConditionKey(func() (interface{}, bool) {
 		r, e := cast.ToInt64E(v)
 		return r, e == nil
-	}, param, InvalidInt64, v, func() string {
-		return "Параметр должен быть целым числом"
-	})
+	}, param, InvalidInt64, v, "validation.int")
 
 	if err != nil {
 		return 0, err
@@ -49,12 +58,10 @@ func CheckInt64(param string, v interface{}) (int64, error) {
 }
 
 func CheckFloat32(param string, v interface{}) (float32, error) {
-	r, err := CheckCondition(func() (interface{}, bool) {
+	r, err := CheckConditionKey(func() (interface{}, bool) {
 		r, e := cast.ToFloat32E(v)
 		return r, e == nil
-	}, param, InvalidInt, v, func() string {
-		return "Параметр должен быть вещественным числом"
-	})
+	}, param, InvalidInt, v, "validation.float")
 
 	if err != nil {
 		return 0, err
@@ -63,12 +70,10 @@ func CheckFloat32(param string, v interface{}) (float32, error) {
 }
 
 func CheckFloat64(param string, v interface{}) (float64, error) {
-	r, err := CheckCondition(func() (interface{}, bool) {
+	r, err := CheckConditionKey(func() (interface{}, bool) {
 		r, e := cast.ToFloat64E(v)
 		return r, e == nil
-	}, param, InvalidInt, v, func() string {
-		return "Параметр должен быть вещественным числом"
-	})
+	}, param, InvalidInt, v, "validation.float")
 
 	if err != nil {
 		return 0, err
@@ -77,12 +82,10 @@ func CheckFloat64(param string, v interface{}) (float64, error) {
 }
 
 func CheckInt(param string, v interface{}) (int, error) {
-	r, err := CheckCondition(func() (interface{}, bool) {
+	r, err := CheckConditionKey(func() (interface{}, bool) {
 		r, e := cast.ToIntE(v)
 		return r, e == nil
-	}, param, InvalidInt, v, func() string {
-		return "Параметр должен быть целым числом"
-	})
+	}, param, InvalidInt, v, "validation.int")
 
 	if err != nil {
 		return 0, err
@@ -90,6 +93,18 @@ func CheckInt(param string, v interface{}) (int, error) {
 	return cast.ToInt(r), nil
 }
 
+// CheckConditionKey is CheckCondition with the message taken from the i18n catalog, so that it can be localized later
+func CheckConditionKey(condition func() (interface{}, bool), param string, reason string, value interface{}, key string, args ...interface{}) (interface{}, error) {
+	res, err := CheckCondition(condition, param, reason, value, func() string {
+		return i18n.Message("", key, args...)
+	})
+	if validationErr, ok := err.(*ValidationError); ok {
+		validationErr.MessageKey = key
+		validationErr.MessageArgs = args
+	}
+	return res, err
+}
+
 func CheckCondition(condition func() (interface{}, bool), param string, reason string, value interface{}, errMsgProvider func() string) (interface{}, error) {
 	res, ok := condition()
 	if !ok {
@@ -104,11 +119,9 @@ func CheckCondition(condition func() (interface{}, bool), param string, reason s
 }
 
 func CheckNotEmptyStr(param string, v string) (string, error) {
-	r, err := CheckCondition(func() (interface{}, bool) {
+	r, err := CheckConditionKey(func() (interface{}, bool) {
 		return v, len(v) > 0
-	}, param, Empty, v, func() string {
-		return "Параметр должен быть непустой строкой"
-	})
+	}, param, Empty, v, "validation.notEmptyStr")
 
 	if err != nil {
 		return "", err
@@ -117,35 +130,29 @@ func CheckNotEmptyStr(param string, v string) (string, error) {
 }
 
 func CheckNotEmpty(param string, value interface{}) (interface{}, error) {
-	return CheckCondition(func() (interface{}, bool) {
+	return CheckConditionKey(func() (interface{}, bool) {
 		switch v := value.(type) {
 		case string:
 			return v, len(v) > 0
 		}
 		return value, value != nil
-	}, param, Empty, value, func() string {
-		return "Параметр не должен быть пустым"
-	})
+	}, param, Empty, value, "validation.notEmpty")
 }
 
 func CheckBool(param string, value interface{}) (bool, error) {
-	r, err := CheckCondition(func() (interface{}, bool) {
+	r, err := CheckConditionKey(func() (interface{}, bool) {
 		b, err := cast.ToBoolE(value)
 		return b, err == nil
-	}, param, InvalidBoolean, value, func() string {
-		return "Параметр должен иметь значение true/false"
-	})
+	}, param, InvalidBoolean, value, "validation.bool")
 
 	return cast.ToBool(r), err
 }
 
 func CheckMatchRegexp(param string, v string, pattern string) (string, error) {
-	r, err := CheckCondition(func() (interface{}, bool) {
+	r, err := CheckConditionKey(func() (interface{}, bool) {
 		matches, _ := regexp.MatchString(pattern, v)
 		return v, matches
-	}, param, ViolatesRegexp, v, func() string {
-		return "Параметр не соответствует формату"
-	})
+	}, param, ViolatesRegexp, v, "validation.regexp")
 
 	if err != nil {
 		return "", err
@@ -154,15 +161,13 @@ func CheckMatchRegexp(param string, v string, pattern string) (string, error) {
 }
 
 func CheckDate(param string, v string) (*time.Time, error) {
-	r, err := CheckCondition(func() (interface{}, bool) {
+	r, err := CheckConditionKey(func() (interface{}, bool) {
 		r, err := time.Parse("02.01.2006", v)
 		if err != nil {
 			r, err = time.Parse("2006-01-02", v)
 		}
 		return r, err == nil
-	}, param, InvalidDate, v, func() string {
-		return ""
-	})
+	}, param, InvalidDate, v, "validation.date")
 
 	if err != nil {
 		return nil, err
@@ -172,28 +177,22 @@ func CheckDate(param string, v string) (*time.Time, error) {
 }
 
 func CheckMaxLen(param string, v string, maxLen int) (interface{}, error) {
-	return CheckCondition(func() (interface{}, bool) {
+	return CheckConditionKey(func() (interface{}, bool) {
 		return v, len(v) <= maxLen
-	}, param, InvalidLength, v, func() string {
-		return fmt.Sprintf("Длина строки превышает %v символов", maxLen)
-	})
+	}, param, InvalidLength, v, "validation.maxLen", maxLen)
 }
 
 func CheckMinLen(param string, v string, minLen int) (interface{}, error) {
-	return CheckCondition(func() (interface{}, bool) {
+	return CheckConditionKey(func() (interface{}, bool) {
 		return v, len(v) >= minLen
-	}, param, InvalidLength, v, func() string {
-		return fmt.Sprintf("Длина строки меньше %v символов", minLen)
-	})
+	}, param, InvalidLength, v, "validation.minLen", minLen)
 }
 
 func CheckEmail(param string, v string) (interface{}, error) {
-	return CheckCondition(func() (interface{}, bool) {
+	return CheckConditionKey(func() (interface{}, bool) {
 		addr, err := mail.ParseAddress(cast.ToString(v))
 		return addr, err == nil
-	}, param, InvalidEmail, v, func() string {
-		return "Некорректный e-mail"
-	})
+	}, param, InvalidEmail, v, "validation.email")
 }
 
 func CheckFirst(param string, a interface{}) error {